@@ -2,10 +2,18 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/geoip"
+	"github.com/nabilfikrisp/url-shortener/internal/common/middleware"
+	"github.com/nabilfikrisp/url-shortener/internal/common/response"
 	"github.com/nabilfikrisp/url-shortener/internal/config"
 	"github.com/nabilfikrisp/url-shortener/internal/database"
+	"github.com/nabilfikrisp/url-shortener/internal/docs"
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 )
 
@@ -18,14 +26,34 @@ func main() {
 	}
 
 	// Auto-migrate model
-	if err := db.AutoMigrate(&url.URLModel{}); err != nil {
+	if err := url.Migrate(db); err != nil {
 		log.Fatal(err)
 	}
 
-	app := fiber.New()
+	stopPurger := url.StartPurger(db, cfg)
+	defer stopPurger()
 
-	urlHandler := url.InitURLHandler(db)
-	url.RegisterRoutes(app, urlHandler)
+	// clicks are recorded without geolocation when the database is missing
+	geo, err := geoip.Open(cfg.GeoIPDatabase)
+	if err != nil {
+		log.Printf("GeoIP disabled, unable to open %s: %v", cfg.GeoIPDatabase, err)
+		geo = geoip.Disabled()
+	}
+	defer geo.Close()
+
+	// stopping the writer flushes clicks still in the queue
+	clicks, stopClicks := url.StartClickWriter(db, cfg)
+	defer stopClicks()
+
+	// handlers return typed errors and leave answering them to ErrorHandler
+	app := fiber.New(fiber.Config{ErrorHandler: response.ErrorHandler})
+	app.Use(middleware.APIKey(cfg.APIKeys))
+
+	// registered before the url routes, whose /:shortToken would match /docs
+	docs.RegisterRoutes(app)
+
+	urlHandler := url.InitURLHandler(db, cfg, clicks, geo)
+	url.RegisterRoutes(app, urlHandler, cfg)
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
@@ -33,5 +61,18 @@ func main() {
 		})
 	})
 
-	log.Fatal(app.Listen(":" + cfg.Port))
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+
+		if err := app.Shutdown(); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+
+	// returns after Shutdown so the deferred cleanups still run
+	if err := app.Listen(":" + cfg.Port); err != nil {
+		log.Printf("listen: %v", err)
+	}
 }
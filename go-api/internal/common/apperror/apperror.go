@@ -0,0 +1,99 @@
+// Package apperror defines the typed errors the service layer returns. Each
+// error carries a stable Code that clients can branch on, independent of the
+// wording of its message; response.ErrorHandler maps it to a status.
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+type Code string
+
+const (
+	// CodeInvalidRequest is a malformed body or query parameter.
+	CodeInvalidRequest Code = "invalid_request"
+	// CodeValidation is a well-formed request with invalid values.
+	CodeValidation Code = "validation_failed"
+	CodeInvalidURL Code = "invalid_url"
+	// CodeOwnDomain is a URL pointing back at this service.
+	CodeOwnDomain    Code = "own_domain"
+	CodeUnauthorized Code = "unauthorized"
+	CodeForbidden    Code = "forbidden"
+	CodeNotFound     Code = "not_found"
+	CodeConflict     Code = "conflict"
+	// CodeExpired is a short URL past its expiry or click limit.
+	CodeExpired  Code = "expired"
+	CodeInternal Code = "internal"
+)
+
+var statuses = map[Code]int{
+	CodeInvalidRequest: http.StatusBadRequest,
+	CodeValidation:     http.StatusUnprocessableEntity,
+	CodeInvalidURL:     http.StatusUnprocessableEntity,
+	CodeOwnDomain:      http.StatusUnprocessableEntity,
+	CodeUnauthorized:   http.StatusUnauthorized,
+	CodeForbidden:      http.StatusForbidden,
+	CodeNotFound:       http.StatusNotFound,
+	CodeConflict:       http.StatusConflict,
+	CodeExpired:        http.StatusGone,
+	CodeInternal:       http.StatusInternalServerError,
+}
+
+// Status is the HTTP status code errors with this code are answered with.
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// FieldError points at one invalid request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Code    Code
+	Message string
+	// Fields lists the request fields at fault, if any.
+	Fields []FieldError
+	// Err is the underlying cause, included in Error.
+	Err error
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap returns an error with code whose cause is err.
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// Field returns an error reporting that one request field is invalid.
+func Field(code Code, field string, message string) *Error {
+	return &Error{Code: code, Message: message, Fields: []FieldError{{Field: field, Message: message}}}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CodeOf returns the code of the first *Error in err's chain, or
+// CodeInternal when there is none.
+func CodeOf(err error) Code {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Code
+	}
+	return CodeInternal
+}
@@ -0,0 +1,76 @@
+// Package geoip resolves client IPs to a country and city using a local
+// MaxMind-format (.mmdb) database. It never makes network lookups.
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code, e.g. "ID".
+	Country string
+	City    string
+}
+
+type Resolver interface {
+	Lookup(ip string) Location
+	Close() error
+}
+
+type noopResolver struct{}
+
+// Disabled returns a Resolver that resolves every IP to an empty Location.
+func Disabled() Resolver {
+	return noopResolver{}
+}
+
+func (noopResolver) Lookup(string) Location { return Location{} }
+func (noopResolver) Close() error           { return nil }
+
+type mmdbResolver struct {
+	reader *maxminddb.Reader
+}
+
+// record holds the fields read from GeoLite2/GeoIP2 Country and City
+// databases. Country databases simply leave City empty.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// Open loads the database at path. An empty path returns Disabled().
+func Open(path string) (Resolver, error) {
+	if path == "" {
+		return Disabled(), nil
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &mmdbResolver{reader: reader}, nil
+}
+
+// Lookup returns an empty Location for invalid, private or unknown IPs.
+func (r *mmdbResolver) Lookup(ip string) Location {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return Location{}
+	}
+
+	var rec record
+	if err := r.reader.Lookup(parsed, &rec); err != nil {
+		return Location{}
+	}
+	return Location{Country: rec.Country.ISOCode, City: rec.City.Names["en"]}
+}
+
+func (r *mmdbResolver) Close() error {
+	return r.reader.Close()
+}
@@ -0,0 +1,29 @@
+package helpers
+
+import "strings"
+
+const Base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// EncodeBase62 encodes n using the given 62 character alphabet, left padding
+// with the alphabet's first character up to minLength.
+func EncodeBase62(n uint64, alphabet string, minLength int) string {
+	base := uint64(len(alphabet))
+
+	var buf []byte
+	for {
+		buf = append(buf, alphabet[n%base])
+		n /= base
+		if n == 0 {
+			break
+		}
+	}
+
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+
+	if len(buf) < minLength {
+		return strings.Repeat(string(alphabet[0]), minLength-len(buf)) + string(buf)
+	}
+	return string(buf)
+}
@@ -0,0 +1,31 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// HashIP returns a keyed SHA-256 of the client IP so visits can be told apart
+// without storing the address itself. An empty ip hashes to an empty string.
+func HashIP(ip string, salt string) string {
+	if ip == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VisitorFingerprint identifies a visitor as the keyed hash of their IP and
+// User-Agent. With a salt that rotates daily the same visitor cannot be linked
+// across days.
+func VisitorFingerprint(ip string, userAgent string, salt []byte) uint64 {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
@@ -0,0 +1,152 @@
+package helpers
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+type NormalizeURLOptions struct {
+	// StripParams lists query parameters to drop. A trailing '*' matches any
+	// parameter with that prefix, e.g. "utm_*".
+	StripParams []string
+	// TrimTrailingSlash drops a trailing '/' from the path, so "/a/" and "/a"
+	// normalize to the same URL.
+	TrimTrailingSlash bool
+}
+
+// NormalizeURL canonicalizes a URL for storage and deduplication: scheme and
+// host are lowercased, default ports dropped and query parameters sorted.
+func NormalizeURL(rawURL string, opts NormalizeURLOptions) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.New("failed to parse URL")
+	}
+	if parsed.Hostname() == "" {
+		return "", errors.New("URL must have a hostname")
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+
+	host := strings.ToLower(parsed.Hostname())
+	port := parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	parsed.Host = host
+
+	if opts.TrimTrailingSlash {
+		parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+		parsed.RawPath = strings.TrimSuffix(parsed.RawPath, "/")
+	}
+	// "http://example.com" and "http://example.com/" are the same resource
+	if parsed.Path == "" {
+		parsed.Path, parsed.RawPath = "/", ""
+	}
+
+	if parsed.RawQuery != "" {
+		pairs := parseQueryPairs(parsed.RawQuery)
+		pairs = slices.DeleteFunc(pairs, func(pair queryPair) bool {
+			return matchesParam(pair.key, opts.StripParams)
+		})
+		parsed.RawQuery = encodeQueryPairs(pairs)
+	}
+	parsed.ForceQuery = false
+
+	return parsed.String(), nil
+}
+
+// SetQueryParams sets each non-empty value in params on the URL's query,
+// replacing a parameter of the same name and keeping every other one. Query
+// parameters are sorted, as by NormalizeURL.
+func SetQueryParams(rawURL string, params map[string]string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.New("failed to parse URL")
+	}
+
+	pairs := parseQueryPairs(parsed.RawQuery)
+	for key, value := range params {
+		if value == "" {
+			continue
+		}
+		pairs = slices.DeleteFunc(pairs, func(pair queryPair) bool { return pair.key == key })
+		pairs = append(pairs, queryPair{key: key, raw: url.QueryEscape(key) + "=" + url.QueryEscape(value)})
+	}
+	parsed.RawQuery = encodeQueryPairs(pairs)
+	parsed.ForceQuery = false
+
+	return parsed.String(), nil
+}
+
+// URLDomain returns the lowercased host of a URL, without port, or an empty
+// string when it cannot be parsed.
+func URLDomain(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// queryPair is one key=value segment of a query string.
+type queryPair struct {
+	key string
+	raw string
+}
+
+// parseQueryPairs splits a raw query on '&'. Pairs that decode are re-encoded
+// the way url.Values.Encode would; pairs that do not, such as "a=1;b=2" or
+// "q=100%", are kept verbatim so URLs that browsers accept still shorten.
+func parseQueryPairs(rawQuery string) []queryPair {
+	var pairs []queryPair
+	for _, segment := range strings.Split(rawQuery, "&") {
+		if segment == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(segment, "=")
+		key, keyErr := url.QueryUnescape(rawKey)
+		value, valueErr := url.QueryUnescape(rawValue)
+		if keyErr != nil || valueErr != nil || strings.Contains(segment, ";") {
+			pairs = append(pairs, queryPair{key: rawKey, raw: segment})
+			continue
+		}
+		pairs = append(pairs, queryPair{key: key, raw: url.QueryEscape(key) + "=" + url.QueryEscape(value)})
+	}
+	return pairs
+}
+
+// encodeQueryPairs joins pairs sorted by key, keeping the order of repeated
+// keys.
+func encodeQueryPairs(pairs []queryPair) string {
+	slices.SortStableFunc(pairs, func(a, b queryPair) int {
+		return strings.Compare(a.key, b.key)
+	})
+	raw := make([]string, len(pairs))
+	for i, pair := range pairs {
+		raw[i] = pair.raw
+	}
+	return strings.Join(raw, "&")
+}
+
+func matchesParam(key string, patterns []string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// TokenGenerator produces the short token for an original URL. Attempt starts
+// at 0 and is increased by the caller when the previous token was taken.
+type TokenGenerator interface {
+	Generate(original string, attempt int) (string, error)
+}
+
+// SequenceFunc returns the next value of a monotonically increasing sequence.
+type SequenceFunc func() (uint64, error)
+
+type hashTokenGenerator struct {
+	length int
+}
+
+// HashAttempts is how many attempts of the hash generator are derived from
+// the original URL alone. Later attempts add random bytes, so a URL shared by
+// many differently configured links still gets a free token.
+const HashAttempts = 5
+
+// NewHashTokenGenerator derives the token from the SHA-1 of the original URL,
+// so the same URL always yields the same token. Retries salt the input with
+// the attempt number, and from HashAttempts on with random bytes.
+func NewHashTokenGenerator(length int) TokenGenerator {
+	return &hashTokenGenerator{length: length}
+}
+
+func (g *hashTokenGenerator) Generate(original string, attempt int) (string, error) {
+	input := original
+	switch {
+	case attempt >= HashAttempts:
+		salt := make([]byte, 8)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		input = original + "#" + hex.EncodeToString(salt)
+	case attempt > 0:
+		input = original + "#" + strconv.Itoa(attempt)
+	}
+
+	hash := sha1.Sum([]byte(input))
+	return hex.EncodeToString(hash[:])[:g.length], nil
+}
+
+type randomTokenGenerator struct {
+	length int
+}
+
+// NewRandomTokenGenerator returns random base62 tokens of the given length.
+func NewRandomTokenGenerator(length int) TokenGenerator {
+	return &randomTokenGenerator{length: length}
+}
+
+func (g *randomTokenGenerator) Generate(_ string, _ int) (string, error) {
+	max := big.NewInt(int64(len(Base62Alphabet)))
+
+	token := make([]byte, g.length)
+	for i := range token {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		token[i] = Base62Alphabet[n.Int64()]
+	}
+	return string(token), nil
+}
+
+type sequenceTokenGenerator struct {
+	next      SequenceFunc
+	minLength int
+}
+
+// NewSequenceTokenGenerator base62 encodes the next sequence value.
+func NewSequenceTokenGenerator(next SequenceFunc, minLength int) TokenGenerator {
+	return &sequenceTokenGenerator{next: next, minLength: minLength}
+}
+
+func (g *sequenceTokenGenerator) Generate(_ string, _ int) (string, error) {
+	n, err := g.next()
+	if err != nil {
+		return "", err
+	}
+	return EncodeBase62(n, Base62Alphabet, g.minLength), nil
+}
+
+// hashidsMixSpace bounds the permutation applied to sequence values; 62^10 is
+// the largest power of 62 that fits in a uint64.
+const hashidsMixSpace uint64 = 839299365868340224
+
+// hashidsMultiplier is coprime with 62, which makes the mix a bijection.
+const hashidsMultiplier uint64 = 1580030173
+
+type hashidsTokenGenerator struct {
+	next      SequenceFunc
+	alphabet  string
+	offset    uint64
+	space     uint64
+	minLength int
+}
+
+// NewHashidsTokenGenerator encodes sequence values with a salt-shuffled
+// alphabet and a salt-keyed permutation, so consecutive IDs do not produce
+// visibly consecutive tokens.
+func NewHashidsTokenGenerator(next SequenceFunc, salt string, minLength int) TokenGenerator {
+	space := hashidsMixSpace
+	if minLength > 0 && minLength < 10 {
+		space = 1
+		for range minLength {
+			space *= uint64(len(Base62Alphabet))
+		}
+	}
+
+	saltHash := sha1.Sum([]byte(salt))
+
+	return &hashidsTokenGenerator{
+		next:      next,
+		alphabet:  shuffleAlphabet(Base62Alphabet, salt),
+		offset:    binary.BigEndian.Uint64(saltHash[:8]) % space,
+		space:     space,
+		minLength: minLength,
+	}
+}
+
+func (g *hashidsTokenGenerator) Generate(_ string, _ int) (string, error) {
+	n, err := g.next()
+	if err != nil {
+		return "", err
+	}
+	if n >= g.space {
+		return "", errors.New("sequence exhausted the configured token space")
+	}
+
+	hi, lo := bits.Mul64(n, hashidsMultiplier)
+	mixed := (bits.Rem64(hi, lo, g.space) + g.offset) % g.space
+
+	return EncodeBase62(mixed, g.alphabet, g.minLength), nil
+}
+
+// shuffleAlphabet is the hashids consistent shuffle: the same salt always
+// produces the same permutation of the alphabet.
+func shuffleAlphabet(alphabet string, salt string) string {
+	result := []byte(alphabet)
+	if salt == "" {
+		return string(result)
+	}
+
+	for i, v, p := len(result)-1, 0, 0; i > 0; i-- {
+		v %= len(salt)
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		result[i], result[j] = result[j], result[i]
+		v++
+	}
+	return string(result)
+}
@@ -0,0 +1,94 @@
+// Package hll implements HyperLogLog sketches for approximate distinct counts.
+// Sketches of the same precision can be merged, so daily sketches can be
+// combined into the count for any range of days.
+package hll
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// Precision is the number of hash bits used to pick a register. 2^12
+// registers take 4 KiB and give a standard error of about 1.6%.
+const Precision = 12
+
+const registers = 1 << Precision
+
+type Sketch struct {
+	registers []byte
+}
+
+func New() *Sketch {
+	return &Sketch{registers: make([]byte, registers)}
+}
+
+// Add records a 64-bit hash of an element. Hashes must be uniformly
+// distributed, e.g. taken from a cryptographic hash.
+func (s *Sketch) Add(hash uint64) {
+	index := hash >> (64 - Precision)
+	// the sentinel bit bounds the rank when the remaining bits are all zero
+	rest := hash<<Precision | 1<<(Precision-1)
+	rank := byte(bits.LeadingZeros64(rest) + 1)
+
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// Merge folds other into s, after which s counts the union of both.
+func (s *Sketch) Merge(other *Sketch) {
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+// Estimate returns the approximate number of distinct hashes added.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(registers)
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// MarshalBinary returns the registers, one byte each.
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	data := make([]byte, len(s.registers))
+	copy(data, s.registers)
+	return data, nil
+}
+
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) != registers {
+		return errors.New("hll: sketch has the wrong size")
+	}
+	s.registers = make([]byte, registers)
+	copy(s.registers, data)
+	return nil
+}
+
+// FromBytes decodes a sketch produced by MarshalBinary.
+func FromBytes(data []byte) (*Sketch, error) {
+	s := new(Sketch)
+	if err := s.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+)
+
+const (
+	APIKeyHeader = "X-API-Key"
+	ownerLocal   = "owner"
+)
+
+var errUnknownAPIKey = apperror.New(apperror.CodeUnauthorized, "Invalid API key, the provided X-API-Key is not recognized")
+
+// APIKey resolves the X-API-Key header to its owner. Requests without a key
+// continue anonymously; requests with an unknown key are rejected.
+func APIKey(keys map[string]string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(APIKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+
+		owner, ok := keys[key]
+		if !ok {
+			return errUnknownAPIKey
+		}
+
+		c.Locals(ownerLocal, owner)
+		return c.Next()
+	}
+}
+
+// Owner returns the caller resolved by APIKey, or "" for anonymous requests.
+func Owner(c *fiber.Ctx) string {
+	owner, _ := c.Locals(ownerLocal).(string)
+	return owner
+}
@@ -0,0 +1,106 @@
+// Package referrer reduces Referer headers to the traffic source they stand
+// for: a well-known name such as "google", or else the registrable domain.
+package referrer
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// Direct is the source of requests without a Referer.
+	Direct = "direct"
+	// Unknown is the source of referrers that cannot be parsed.
+	Unknown = "unknown"
+)
+
+// groupsByLabel groups brands that use many TLDs by the first label of their
+// registrable domain, e.g. google.com and google.co.uk.
+var groupsByLabel = map[string]string{
+	"google":     "google",
+	"bing":       "bing",
+	"yahoo":      "yahoo",
+	"yandex":     "yandex",
+	"baidu":      "baidu",
+	"duckduckgo": "duckduckgo",
+	"ecosia":     "ecosia",
+	"amazon":     "amazon",
+	"facebook":   "facebook",
+	"instagram":  "instagram",
+	"linkedin":   "linkedin",
+	"pinterest":  "pinterest",
+	"reddit":     "reddit",
+	"tiktok":     "tiktok",
+	"youtube":    "youtube",
+}
+
+// groupsByDomain groups registrable domains that do not share a label with
+// their brand, such as link shorteners and app domains.
+var groupsByDomain = map[string]string{
+	"fb.com":              "facebook",
+	"fb.me":               "facebook",
+	"messenger.com":       "facebook",
+	"t.co":                "x",
+	"x.com":               "x",
+	"twitter.com":         "x",
+	"lnkd.in":             "linkedin",
+	"youtu.be":            "youtube",
+	"pin.it":              "pinterest",
+	"redd.it":             "reddit",
+	"whatsapp.com":        "whatsapp",
+	"wa.me":               "whatsapp",
+	"t.me":                "telegram",
+	"telegram.org":        "telegram",
+	"slack.com":           "slack",
+	"discord.com":         "discord",
+	"discordapp.com":      "discord",
+	"ycombinator.com":     "hackernews",
+	"github.com":          "github",
+	"mail.google.com":     "gmail",
+	"outlook.live.com":    "outlook",
+	"teams.microsoft.com": "teams",
+}
+
+// Source returns the traffic source of a Referer header value.
+func Source(referer string) string {
+	referer = strings.TrimSpace(referer)
+	if referer == "" {
+		return Direct
+	}
+
+	parsed, err := url.Parse(referer)
+	if err != nil || parsed.Hostname() == "" {
+		return Unknown
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	// app referrers such as android-app://com.slack carry the app id as host
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return host
+	}
+
+	if group, ok := groupsByDomain[host]; ok {
+		return group
+	}
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		// bare public suffixes have no registrable domain
+		return host
+	}
+	if group, ok := groupsByDomain[domain]; ok {
+		return group
+	}
+
+	label, _, _ := strings.Cut(domain, ".")
+	if group, ok := groupsByLabel[label]; ok {
+		return group
+	}
+	return domain
+}
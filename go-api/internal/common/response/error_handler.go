@@ -0,0 +1,98 @@
+package response
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+)
+
+// MIMEProblemJSON is the media type of RFC 7807 problem details.
+const MIMEProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body, sent instead of Error to
+// clients that accept application/problem+json.
+type Problem struct {
+	Type     string                `json:"type"`
+	Title    string                `json:"title"`
+	Status   int                   `json:"status"`
+	Detail   string                `json:"detail,omitempty"`
+	Instance string                `json:"instance,omitempty"`
+	Code     apperror.Code         `json:"code"`
+	Errors   []apperror.FieldError `json:"errors,omitempty"`
+}
+
+// ProblemType is the type URI of problems with code.
+func ProblemType(code apperror.Code) string {
+	return "urn:url-shortener:problem:" + string(code)
+}
+
+// codesByStatus names the errors fiber raises itself, such as unknown routes.
+var codesByStatus = map[int]apperror.Code{
+	fiber.StatusUnauthorized:        apperror.CodeUnauthorized,
+	fiber.StatusForbidden:           apperror.CodeForbidden,
+	fiber.StatusNotFound:            apperror.CodeNotFound,
+	fiber.StatusConflict:            apperror.CodeConflict,
+	fiber.StatusGone:                apperror.CodeExpired,
+	fiber.StatusUnprocessableEntity: apperror.CodeValidation,
+}
+
+// ErrorHandler is the fiber ErrorHandler answering every error a handler
+// returns. An *apperror.Error is answered with the status of its code, a
+// *fiber.Error with its own status, and anything else is logged and answered
+// 500 without exposing its message.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var appErr *apperror.Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &appErr) && appErr.Code != apperror.CodeInternal:
+		return writeError(c, appErr.Code.Status(), ErrorResponseParams{
+			Message: capitalize(appErr.Message),
+			Err:     err.Error(),
+			Code:    appErr.Code,
+			Fields:  appErr.Fields,
+		})
+	case errors.As(err, &fiberErr):
+		code, ok := codesByStatus[fiberErr.Code]
+		if !ok && fiberErr.Code < fiber.StatusInternalServerError {
+			code = apperror.CodeInvalidRequest
+		} else if !ok {
+			code = apperror.CodeInternal
+		}
+		return writeError(c, fiberErr.Code, ErrorResponseParams{
+			Message: fiberErr.Message,
+			Code:    code,
+		})
+	default:
+		log.Printf("%s %s: %v", c.Method(), c.Path(), err)
+		return writeError(c, fiber.StatusInternalServerError, ErrorResponseParams{
+			Message: "Internal server error",
+			Code:    apperror.CodeInternal,
+		})
+	}
+}
+
+func writeError(c *fiber.Ctx, status int, p ErrorResponseParams) error {
+	if c.Accepts(fiber.MIMEApplicationJSON, MIMEProblemJSON) != MIMEProblemJSON {
+		return c.Status(status).JSON(ErrorPayload(p))
+	}
+
+	return c.Status(status).JSON(Problem{
+		Type:     ProblemType(p.Code),
+		Title:    p.Message,
+		Status:   status,
+		Detail:   p.Err,
+		Instance: c.OriginalURL(),
+		Code:     p.Code,
+		Errors:   p.Fields,
+	}, MIMEProblemJSON)
+}
+
+func capitalize(message string) string {
+	if message == "" {
+		return message
+	}
+	return strings.ToUpper(message[:1]) + message[1:]
+}
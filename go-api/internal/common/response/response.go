@@ -1,5 +1,7 @@
 package response
 
+import "github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+
 type Success struct {
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
@@ -8,6 +10,9 @@ type Success struct {
 type Error struct {
 	Message string `json:"message"`
 	Error   string `json:"error,omitempty"`
+	// Code is the stable apperror code clients branch on.
+	Code   apperror.Code         `json:"code,omitempty"`
+	Fields []apperror.FieldError `json:"fields,omitempty"`
 }
 
 type SuccessPayloadParams struct {
@@ -25,11 +30,15 @@ func SuccessPayload(p SuccessPayloadParams) Success {
 type ErrorResponseParams struct {
 	Message string
 	Err     string
+	Code    apperror.Code
+	Fields  []apperror.FieldError
 }
 
 func ErrorPayload(p ErrorResponseParams) Error {
 	return Error{
 		Message: p.Message,
 		Error:   p.Err,
+		Code:    p.Code,
+		Fields:  p.Fields,
 	}
 }
@@ -0,0 +1,110 @@
+// Package useragent classifies User-Agent headers into browser, operating
+// system and device class. It runs offline from the rules embedded in
+// rules.json; the first rule that matches wins.
+package useragent
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+
+	// Other is reported when no rule matches.
+	Other = "Other"
+)
+
+type Info struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+// IsBot reports whether the agent matched one of the bot rules.
+func (i Info) IsBot() bool {
+	return i.Device == DeviceBot
+}
+
+//go:embed rules.json
+var rulesJSON []byte
+
+type rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	Exclude string `json:"exclude"`
+
+	pattern *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+type ruleSet struct {
+	Bots     []*rule `json:"bots"`
+	Browsers []*rule `json:"browsers"`
+	OS       []*rule `json:"os"`
+	Devices  []*rule `json:"devices"`
+}
+
+var rules = mustLoadRules(rulesJSON)
+
+func mustLoadRules(data []byte) *ruleSet {
+	set := new(ruleSet)
+	if err := json.Unmarshal(data, set); err != nil {
+		panic(fmt.Sprintf("useragent: invalid rules.json: %v", err))
+	}
+
+	for _, group := range [][]*rule{set.Bots, set.Browsers, set.OS, set.Devices} {
+		for _, r := range group {
+			// rules are case-insensitive
+			r.pattern = regexp.MustCompile("(?i)" + r.Pattern)
+			if r.Exclude != "" {
+				r.exclude = regexp.MustCompile("(?i)" + r.Exclude)
+			}
+		}
+	}
+	return set
+}
+
+func match(group []*rule, ua string) (string, bool) {
+	for _, r := range group {
+		if r.pattern.MatchString(ua) && (r.exclude == nil || !r.exclude.MatchString(ua)) {
+			return r.Name, true
+		}
+	}
+	return "", false
+}
+
+// Parse classifies ua. Bots are reported with the bot's name as browser and
+// DeviceBot as device. An empty ua is treated as a bot, since browsers always
+// send one.
+func Parse(ua string) Info {
+	if ua == "" {
+		return Info{Browser: Other, OS: Other, Device: DeviceBot}
+	}
+
+	os, ok := match(rules.OS, ua)
+	if !ok {
+		os = Other
+	}
+
+	if bot, ok := match(rules.Bots, ua); ok {
+		return Info{Browser: bot, OS: os, Device: DeviceBot}
+	}
+
+	browser, ok := match(rules.Browsers, ua)
+	if !ok {
+		browser = Other
+	}
+
+	device, ok := match(rules.Devices, ua)
+	if !ok {
+		device = DeviceDesktop
+	}
+
+	return Info{Browser: browser, OS: os, Device: device}
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,10 +17,73 @@ const (
 	Development GoEnv = "development"
 )
 
+// TokenStrategy selects how short tokens are generated for new URLs.
+type TokenStrategy string
+
+const (
+	TokenStrategyHash     TokenStrategy = "hash"
+	TokenStrategyRandom   TokenStrategy = "random"
+	TokenStrategySequence TokenStrategy = "sequence"
+	TokenStrategyHashids  TokenStrategy = "hashids"
+)
+
 type Config struct {
 	Port        string
 	DatabaseURL string
 	GoEnv       GoEnv
+
+	TokenStrategy TokenStrategy
+	// TokenLength is the token length for hash and random strategies and the
+	// minimum length for sequence and hashids, 6 to 20. Zero means the
+	// strategy default.
+	TokenLength int
+	TokenSalt   string
+
+	// ExpiredRedirectURL is where expired links redirect to. When empty they
+	// answer 410 Gone instead.
+	ExpiredRedirectURL string
+
+	// RedirectType is the status code of links created without one: 301, 302,
+	// 307 or 308. Zero means 302.
+	RedirectType int
+	// RedirectCacheMaxAge is how long browsers and CDNs may cache permanent
+	// redirects. Zero means one day.
+	RedirectCacheMaxAge time.Duration
+
+	// StripQueryParams are dropped from URLs before storage, e.g. "utm_*".
+	StripQueryParams  []string
+	TrimTrailingSlash bool
+
+	// BatchMaxURLs caps how many URLs one POST /shorten/batch may carry. Zero
+	// means the default of 100.
+	BatchMaxURLs int
+
+	// APIKeys maps an API key to the owner it identifies.
+	APIKeys map[string]string
+
+	// PurgeAfter is how long soft-deleted URLs are kept before being
+	// hard-deleted. Zero disables purging.
+	PurgeAfter    time.Duration
+	PurgeInterval time.Duration
+
+	// PasswordMaxAttempts failed unlocks per IP are allowed within
+	// PasswordAttemptWindow. Zero values fall back to 5 per minute.
+	PasswordMaxAttempts   int
+	PasswordAttemptWindow time.Duration
+
+	// IPHashSalt keys the hash of client IPs stored with click events.
+	IPHashSalt string
+
+	// ClickAsync queues clicks and writes them in batches in the background
+	// instead of during the redirect. Zero sizes and interval use defaults.
+	ClickAsync         bool
+	ClickQueueSize     int
+	ClickBatchSize     int
+	ClickFlushInterval time.Duration
+
+	// GeoIPDatabase is the path of a MaxMind-format .mmdb file used to
+	// geolocate clicks. Empty disables geolocation.
+	GeoIPDatabase string
 }
 
 func Load() *Config {
@@ -37,6 +103,37 @@ func Load() *Config {
 		Port:        verifyEnv("PORT"),
 		DatabaseURL: verifyEnv("DATABASE_URL"),
 		GoEnv:       goEnv,
+
+		TokenStrategy: verifyTokenStrategy(optionalEnv("TOKEN_STRATEGY", string(TokenStrategyHash))),
+		TokenLength:   verifyTokenLength(optionalIntEnv("TOKEN_LENGTH", 0)),
+		TokenSalt:     optionalEnv("TOKEN_SALT", ""),
+
+		ExpiredRedirectURL: optionalEnv("EXPIRED_REDIRECT_URL", ""),
+
+		RedirectType:        verifyRedirectType(optionalIntEnv("REDIRECT_TYPE", 302)),
+		RedirectCacheMaxAge: optionalDurationEnv("REDIRECT_CACHE_MAX_AGE", 24*time.Hour),
+
+		StripQueryParams:  optionalListEnv("STRIP_QUERY_PARAMS"),
+		TrimTrailingSlash: optionalBoolEnv("TRIM_TRAILING_SLASH", false),
+
+		BatchMaxURLs: optionalIntEnv("BATCH_MAX_URLS", 0),
+
+		APIKeys: verifyAPIKeys(optionalListEnv("API_KEYS")),
+
+		PurgeAfter:    time.Duration(optionalIntEnv("PURGE_AFTER_DAYS", 30)) * 24 * time.Hour,
+		PurgeInterval: optionalDurationEnv("PURGE_INTERVAL", time.Hour),
+
+		PasswordMaxAttempts:   optionalIntEnv("PASSWORD_MAX_ATTEMPTS", 5),
+		PasswordAttemptWindow: optionalDurationEnv("PASSWORD_ATTEMPT_WINDOW", time.Minute),
+
+		IPHashSalt: verifyIPHashSalt(optionalEnv("IP_HASH_SALT", ""), goEnv),
+
+		ClickAsync:         optionalBoolEnv("CLICK_ASYNC", true),
+		ClickQueueSize:     optionalIntEnv("CLICK_QUEUE_SIZE", 10000),
+		ClickBatchSize:     optionalIntEnv("CLICK_BATCH_SIZE", 500),
+		ClickFlushInterval: optionalDurationEnv("CLICK_FLUSH_INTERVAL", time.Second),
+
+		GeoIPDatabase: optionalEnv("GEOIP_DATABASE", ""),
 	}
 }
 
@@ -47,3 +144,110 @@ func verifyEnv(key string) string {
 	}
 	return val
 }
+
+func optionalEnv(key string, fallback string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	return val
+}
+
+func optionalIntEnv(key string, fallback int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		panic(fmt.Sprintf("env var %s must be an integer: %v", key, err))
+	}
+	return n
+}
+
+func optionalDurationEnv(key string, fallback time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		panic(fmt.Sprintf("env var %s must be a duration like 1h: %v", key, err))
+	}
+	return d
+}
+
+func optionalBoolEnv(key string, fallback bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		panic(fmt.Sprintf("env var %s must be a boolean: %v", key, err))
+	}
+	return b
+}
+
+// optionalListEnv splits a comma separated env var, skipping empty items.
+func optionalListEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func verifyTokenStrategy(val string) TokenStrategy {
+	strategy := TokenStrategy(val)
+	switch strategy {
+	case TokenStrategyHash, TokenStrategyRandom, TokenStrategySequence, TokenStrategyHashids:
+		return strategy
+	}
+	panic(fmt.Sprintf("unknown TOKEN_STRATEGY: %s", val))
+}
+
+// verifyAPIKeys parses "key:owner" pairs.
+func verifyAPIKeys(pairs []string) map[string]string {
+	keys := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, owner, ok := strings.Cut(pair, ":")
+		if !ok || key == "" || owner == "" {
+			panic(fmt.Sprintf("API_KEYS entries must look like key:owner, got %q", pair))
+		}
+		keys[key] = owner
+	}
+	return keys
+}
+
+// verifyTokenLength accepts 0, meaning the strategy default, or a length
+// from 6 up to the short_token column size. Shorter tokens leave so few
+// candidates that collisions soon use up the attempts.
+func verifyTokenLength(n int) int {
+	if n != 0 && (n < 6 || n > 20) {
+		panic(fmt.Sprintf("TOKEN_LENGTH must be 0 for the strategy default or between 6 and 20, got %d", n))
+	}
+	return n
+}
+
+func verifyRedirectType(status int) int {
+	switch status {
+	case 301, 302, 307, 308:
+		return status
+	}
+	panic(fmt.Sprintf("REDIRECT_TYPE must be 301, 302, 307 or 308, got %d", status))
+}
+
+// verifyIPHashSalt refuses an empty salt outside development: unkeyed, the
+// hash of an IPv4 address is reversed by hashing the whole address space.
+func verifyIPHashSalt(salt string, goEnv GoEnv) string {
+	if salt == "" && goEnv != Development {
+		panic("IP_HASH_SALT is required outside development")
+	}
+	return salt
+}
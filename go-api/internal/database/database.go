@@ -24,7 +24,8 @@ func Connect(cfg *config.Config) (*gorm.DB, error) {
 	// wait for db to spin up if usign docker
 	for range 10 {
 		db, err = gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{
-			Logger: logger.Default.LogMode(logMode),
+			Logger:         logger.Default.LogMode(logMode),
+			TranslateError: true,
 		})
 
 		if err == nil {
@@ -0,0 +1,152 @@
+// Package docs serves the OpenAPI document of the API and a page rendering
+// it. Both are embedded, so the docs work offline and ship with the binary.
+package docs
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed openapi.json
+var source []byte
+
+//go:embed docs.html
+var page []byte
+
+// OpenAPI is the OpenAPI 3.1 document describing every route of the API. The
+// versioned operations are maintained by hand next to the routes they
+// describe; the deprecated unversioned aliases are generated from them.
+var OpenAPI = mustWithAliases(source)
+
+// aliasKey marks a path item whose operations are also served, deprecated,
+// under the unversioned path it names.
+const aliasKey = "x-unversioned-alias"
+
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func RegisterRoutes(app *fiber.App) {
+	app.Get("/openapi.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		return c.Send(OpenAPI)
+	})
+	app.Get("/docs", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.Send(page)
+	})
+}
+
+func mustWithAliases(src []byte) []byte {
+	out, err := withAliases(src)
+	if err != nil {
+		panic(fmt.Sprintf("docs: invalid openapi.json: %v", err))
+	}
+	return out
+}
+
+// withAliases replaces every aliasKey marker of src with a copy of its path
+// item under the unversioned path, tagged Deprecated and with the Deprecation
+// and Link headers added to each response.
+func withAliases(src []byte) ([]byte, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(src, &doc); err != nil {
+		return nil, err
+	}
+	paths, _ := doc["paths"].(map[string]any)
+	components, _ := doc["components"].(map[string]any)
+	responses, _ := components["responses"].(map[string]any)
+
+	for path, value := range paths {
+		item, _ := value.(map[string]any)
+		alias, ok := item[aliasKey].(string)
+		if !ok {
+			continue
+		}
+		delete(item, aliasKey)
+		if _, taken := paths[alias]; taken {
+			return nil, fmt.Errorf("alias %s of %s is already documented", alias, path)
+		}
+
+		legacy := make(map[string]any, len(item))
+		for key, value := range item {
+			if !slices.Contains(methods, key) {
+				legacy[key] = copyJSON(value)
+				continue
+			}
+			op, err := aliasOperation(strings.ToUpper(key), path, copyJSON(value), responses)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", key, path, err)
+			}
+			legacy[key] = op
+		}
+		paths[alias] = legacy
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func aliasOperation(method, path string, value any, responses map[string]any) (map[string]any, error) {
+	op, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("operation is not an object")
+	}
+	id, _ := op["operationId"].(string)
+	op["operationId"] = id + "Legacy"
+	op["tags"] = []any{"Deprecated"}
+	op["deprecated"] = true
+	op["description"] = fmt.Sprintf("Deprecated alias of %s %s. Responses carry a Deprecation header and a Link to the successor.", method, path)
+
+	opResponses, _ := op["responses"].(map[string]any)
+	for status, value := range opResponses {
+		res, _ := value.(map[string]any)
+		// A shared response cannot take extra headers, so it is inlined.
+		if ref, ok := res["$ref"].(string); ok {
+			shared, ok := responses[strings.TrimPrefix(ref, "#/components/responses/")]
+			if !ok {
+				return nil, fmt.Errorf("unknown response %s", ref)
+			}
+			res, _ = copyJSON(shared).(map[string]any)
+		}
+		headers, _ := res["headers"].(map[string]any)
+		if headers == nil {
+			headers = make(map[string]any, 2)
+		}
+		headers["Deprecation"] = map[string]any{"$ref": "#/components/headers/Deprecation"}
+		headers["Link"] = map[string]any{"$ref": "#/components/headers/Link"}
+		res["headers"] = headers
+		opResponses[status] = res
+	}
+	return op, nil
+}
+
+// copyJSON deep-copies a value decoded from JSON.
+func copyJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, value := range v {
+			out[key] = copyJSON(value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, value := range v {
+			out[i] = copyJSON(value)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,217 @@
+package url
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+	"gorm.io/gorm"
+)
+
+// ClickRecorder stores the click events produced by redirects.
+type ClickRecorder interface {
+	Record(event *ClickEvent) error
+}
+
+type syncClickRecorder struct {
+	repo URLRepo
+}
+
+// NewSyncClickRecorder writes every click to the database before returning.
+func NewSyncClickRecorder(repo URLRepo) ClickRecorder {
+	return &syncClickRecorder{repo: repo}
+}
+
+func (r *syncClickRecorder) Record(event *ClickEvent) error {
+	affectedRows, err := r.repo.RecordClick(event)
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		return errors.New("unable to update click statistics")
+	}
+	return nil
+}
+
+const (
+	defaultClickQueueSize     = 10000
+	defaultClickBatchSize     = 500
+	defaultClickFlushInterval = time.Second
+)
+
+// ClickWriterParams configures a ClickWriter. Zero values use the defaults.
+type ClickWriterParams struct {
+	// QueueSize bounds how many clicks may wait for a flush. Clicks arriving
+	// while the queue is full are dropped.
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// ClickWriter queues clicks in memory and writes them in batches from a
+// background goroutine, keeping the database off the redirect path.
+type ClickWriter struct {
+	repo      URLRepo
+	queue     chan *ClickEvent
+	batchSize int
+	interval  time.Duration
+
+	dropped   atomic.Uint64
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewClickWriter(repo URLRepo, params ClickWriterParams) *ClickWriter {
+	if params.QueueSize <= 0 {
+		params.QueueSize = defaultClickQueueSize
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = defaultClickBatchSize
+	}
+	if params.FlushInterval <= 0 {
+		params.FlushInterval = defaultClickFlushInterval
+	}
+
+	w := &ClickWriter{
+		repo:      repo,
+		queue:     make(chan *ClickEvent, params.QueueSize),
+		batchSize: params.BatchSize,
+		interval:  params.FlushInterval,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Record queues the click without blocking. It never fails; clicks that do
+// not fit in the queue are counted in Dropped.
+func (w *ClickWriter) Record(event *ClickEvent) error {
+	select {
+	case <-w.done:
+		w.dropped.Add(1)
+		return nil
+	default:
+	}
+
+	select {
+	case w.queue <- event:
+	default:
+		w.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped returns how many clicks were lost because the queue was full, the
+// writer was closed or a flush failed.
+func (w *ClickWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Close flushes every queued click and stops the writer.
+func (w *ClickWriter) Close() {
+	w.closeOnce.Do(func() { close(w.done) })
+	<-w.stopped
+}
+
+func (w *ClickWriter) run() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	batch := make([]*ClickEvent, 0, w.batchSize)
+	var reported uint64
+
+	flush := func() {
+		if len(batch) > 0 {
+			if err := w.repo.RecordClicks(batch); err != nil {
+				w.dropped.Add(uint64(len(batch)))
+				log.Printf("record %d clicks: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+
+		if dropped := w.Dropped(); dropped > reported {
+			log.Printf("click writer dropped %d clicks so far", dropped)
+			reported = dropped
+		}
+	}
+
+	for {
+		select {
+		case event := <-w.queue:
+			batch = append(batch, event)
+			if len(batch) >= w.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.done:
+			for {
+				select {
+				case event := <-w.queue:
+					batch = append(batch, event)
+					if len(batch) >= w.batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// StartClickWriter starts the background click writer when cfg.ClickAsync is
+// set. It returns nil when clicks should be recorded synchronously. Calling
+// the returned function drains the queue and stops the writer.
+func StartClickWriter(db *gorm.DB, cfg *config.Config) (ClickRecorder, func()) {
+	if !cfg.ClickAsync {
+		return nil, func() {}
+	}
+
+	writer := NewClickWriter(NewURLRepo(db), ClickWriterParams{
+		QueueSize:     cfg.ClickQueueSize,
+		BatchSize:     cfg.ClickBatchSize,
+		FlushInterval: cfg.ClickFlushInterval,
+	})
+	return writer, writer.Close
+}
+
+// clickDelta is how many human and bot clicks a flush adds to one URL.
+type clickDelta struct {
+	Human int
+	Bot   int
+}
+
+// clickDeltas sums clicks per URL, ordered by URL ID so concurrent flushes lock
+// rows in the same order.
+func clickDeltas(events []*ClickEvent) ([]uint, map[uint]*clickDelta) {
+	deltas := make(map[uint]*clickDelta)
+	for _, event := range events {
+		delta, ok := deltas[event.URLID]
+		if !ok {
+			delta = new(clickDelta)
+			deltas[event.URLID] = delta
+		}
+		if event.IsBot {
+			delta.Bot++
+		} else {
+			delta.Human++
+		}
+	}
+
+	ids := make([]uint, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, deltas
+}
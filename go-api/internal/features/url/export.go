@@ -0,0 +1,251 @@
+package url
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportNDJSON ExportFormat = "ndjson"
+)
+
+func (f ExportFormat) Valid() bool {
+	return f == ExportCSV || f == ExportNDJSON
+}
+
+// ContentType is the media type an export in this format is served as.
+func (f ExportFormat) ContentType() string {
+	if f == ExportNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ExportData selects what an export contains: one row per click event or one
+// row per link and day.
+type ExportData string
+
+const (
+	ExportEvents ExportData = "events"
+	ExportDaily  ExportData = "daily"
+)
+
+func (d ExportData) Valid() bool {
+	return d == ExportEvents || d == ExportDaily
+}
+
+type ExportParams struct {
+	// ShortToken limits the export to one URL. When empty every URL of Owner
+	// is exported.
+	ShortToken string
+	// Owner is the caller. The click events of a single URL are only
+	// exported to its owner; its daily counts are public like its stats.
+	Owner  string
+	Format ExportFormat
+	Data   ExportData
+	// From and To bound the clicks exported, From inclusive and To exclusive.
+	// When nil the export is unbounded on that side.
+	From *time.Time
+	To   *time.Time
+	// Location is used for timestamps and day boundaries. Defaults to UTC.
+	Location *time.Location
+	// Campaign, when set, limits the export to URLs created with that
+	// utm_campaign.
+	Campaign string
+}
+
+// ClickExportParams selects the clicks read by the repository export
+// iterators. Exactly one of URLID and Owner is set.
+type ClickExportParams struct {
+	URLID    uint
+	Owner    string
+	From     *time.Time
+	To       *time.Time
+	Timezone string
+	Campaign string
+}
+
+// ExportedClick is one click event row of an export.
+type ExportedClick struct {
+	ShortToken     string    `json:"short_token"`
+	OccurredAt     time.Time `json:"occurred_at"`
+	Referrer       string    `json:"referrer"`
+	ReferrerSource string    `json:"referrer_source"`
+	UserAgent      string    `json:"user_agent"`
+	IPHash         string    `json:"ip_hash"`
+	AcceptLanguage string    `json:"accept_language"`
+	Browser        string    `json:"browser"`
+	OS             string    `json:"os"`
+	Device         string    `json:"device"`
+	Country        string    `json:"country"`
+	City           string    `json:"city"`
+	IsBot          bool      `json:"is_bot"`
+}
+
+var exportedClickColumns = []string{
+	"short_token", "occurred_at", "referrer", "referrer_source", "user_agent", "ip_hash",
+	"accept_language", "browser", "os", "device", "country", "city", "is_bot",
+}
+
+func (e ExportedClick) record() []string {
+	return []string{
+		e.ShortToken, e.OccurredAt.Format(time.RFC3339), e.Referrer, e.ReferrerSource, e.UserAgent, e.IPHash,
+		e.AcceptLanguage, e.Browser, e.OS, e.Device, e.Country, e.City, strconv.FormatBool(e.IsBot),
+	}
+}
+
+// DailyClickCount is one row of a daily aggregate export.
+type DailyClickCount struct {
+	ShortToken string `json:"short_token"`
+	Date       string `json:"date"`
+	Clicks     int64  `json:"clicks"`
+	BotClicks  int64  `json:"bot_clicks"`
+}
+
+var dailyClickColumns = []string{"short_token", "date", "clicks", "bot_clicks"}
+
+func (d DailyClickCount) record() []string {
+	return []string{d.ShortToken, d.Date, strconv.FormatInt(d.Clicks, 10), strconv.FormatInt(d.BotClicks, 10)}
+}
+
+// StatsExport is a validated export. Rows are read from the database only
+// when it is streamed, so arbitrarily large exports use constant memory.
+type StatsExport struct {
+	Format   ExportFormat
+	Filename string
+	stream   func(w io.Writer) error
+}
+
+// Stream writes the export to w.
+func (e *StatsExport) Stream(w io.Writer) error {
+	return e.stream(w)
+}
+
+// ExportStats validates params and prepares an export of one URL's clicks,
+// or of every URL owned by params.Owner when no short token is given.
+func (s *urlService) ExportStats(params ExportParams) (*StatsExport, error) {
+	if !params.Format.Valid() {
+		return nil, fmt.Errorf("%w: format must be csv or ndjson", ErrInvalidStatsQuery)
+	}
+	if !params.Data.Valid() {
+		return nil, fmt.Errorf("%w: data must be events or daily", ErrInvalidStatsQuery)
+	}
+	if params.From != nil && params.To != nil && !params.From.Before(*params.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidStatsQuery)
+	}
+
+	loc := params.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	query := ClickExportParams{From: params.From, To: params.To, Timezone: loc.String(), Campaign: params.Campaign}
+
+	name := "account"
+	if params.ShortToken != "" {
+		url, err := s.FindByShortToken(params.ShortToken)
+		if err != nil {
+			return nil, err
+		}
+		if params.Data == ExportEvents && !url.OwnedBy(params.Owner) {
+			return nil, ErrNotOwner
+		}
+		query.URLID = url.ID
+		name = url.ShortToken
+	} else if params.Owner != "" {
+		query.Owner = params.Owner
+	} else {
+		return nil, fmt.Errorf("%w: an owner or short token is required", ErrInvalidStatsQuery)
+	}
+
+	export := &StatsExport{
+		Format:   params.Format,
+		Filename: fmt.Sprintf("%s-%s.%s", name, params.Data, params.Format),
+	}
+
+	if params.Data == ExportDaily {
+		export.stream = func(w io.Writer) error {
+			enc := newExportEncoder(w, params.Format, dailyClickColumns)
+			err := s.repo.EachDailyClicks(query, func(row DailyClickCount) error {
+				return enc.encode(row, row.record())
+			})
+			return enc.close(err)
+		}
+		return export, nil
+	}
+
+	export.stream = func(w io.Writer) error {
+		enc := newExportEncoder(w, params.Format, exportedClickColumns)
+		err := s.repo.EachClickEvent(query, func(row ExportedClick) error {
+			row.OccurredAt = row.OccurredAt.In(loc)
+			return enc.encode(row, row.record())
+		})
+		return enc.close(err)
+	}
+	return export, nil
+}
+
+// exportEncoder writes rows either as CSV records or as one JSON object per
+// line.
+type exportEncoder struct {
+	csv     *csv.Writer
+	json    *json.Encoder
+	columns []string
+	started bool
+}
+
+func newExportEncoder(w io.Writer, format ExportFormat, columns []string) *exportEncoder {
+	if format == ExportNDJSON {
+		return &exportEncoder{json: json.NewEncoder(w)}
+	}
+	return &exportEncoder{csv: csv.NewWriter(w), columns: columns}
+}
+
+func (e *exportEncoder) encode(value any, record []string) error {
+	if e.json != nil {
+		return e.json.Encode(value)
+	}
+
+	if !e.started {
+		e.started = true
+		if err := e.csv.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	for i, field := range record {
+		record[i] = csvSafe(field)
+	}
+	return e.csv.Write(record)
+}
+
+// close flushes buffered rows and returns err, or the flush error if err is
+// nil. A CSV export without rows still gets its header.
+func (e *exportEncoder) close(err error) error {
+	if e.csv == nil {
+		return err
+	}
+	if !e.started && err == nil {
+		err = e.csv.Write(e.columns)
+	}
+	e.csv.Flush()
+	if err != nil {
+		return err
+	}
+	return e.csv.Error()
+}
+
+// csvSafe defuses values that spreadsheets would evaluate as formulas, such as
+// a referrer or user agent starting with "=".
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
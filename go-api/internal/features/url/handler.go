@@ -2,88 +2,263 @@ package url
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
 	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/nabilfikrisp/url-shortener/internal/common/middleware"
 	"github.com/nabilfikrisp/url-shortener/internal/common/response"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
 )
 
 type URLHandler interface {
 	Create(c *fiber.Ctx) error
+	CreateBatch(c *fiber.Ctx) error
 	FindByShortToken(c *fiber.Ctx) error
+	FindWithHistory(c *fiber.Ctx) error
+	UpdateDestination(c *fiber.Ctx) error
+	Delete(c *fiber.Ctx) error
+	Restore(c *fiber.Ctx) error
 	RedirectToOriginal(c *fiber.Ctx) error
+	HeadRedirect(c *fiber.Ctx) error
+	Preview(c *fiber.Ctx) error
+	Unlock(c *fiber.Ctx) error
+	ClickTimeseries(c *fiber.Ctx) error
+	UniqueVisitors(c *fiber.Ctx) error
+	ExportStats(c *fiber.Ctx) error
+	ExportAccountStats(c *fiber.Ctx) error
+	List(c *fiber.Ctx) error
 }
 type urlHandler struct {
-	service URLService
+	service            URLService
+	expiredRedirectURL string
+	batchMaxURLs       int
+	redirectType       int
+	redirectMaxAge     time.Duration
 }
 
-func NewURLHandler(service URLService) URLHandler {
+const (
+	defaultBatchMaxURLs   = 100
+	defaultRedirectType   = fiber.StatusFound
+	defaultRedirectMaxAge = 24 * time.Hour
+)
+
+func NewURLHandler(service URLService, cfg *config.Config) URLHandler {
+	batchMaxURLs := cfg.BatchMaxURLs
+	if batchMaxURLs == 0 {
+		batchMaxURLs = defaultBatchMaxURLs
+	}
+
+	redirectType := cfg.RedirectType
+	if redirectType == 0 {
+		redirectType = defaultRedirectType
+	}
+	redirectMaxAge := cfg.RedirectCacheMaxAge
+	if redirectMaxAge == 0 {
+		redirectMaxAge = defaultRedirectMaxAge
+	}
+
 	return &urlHandler{
-		service: service,
+		service:            service,
+		expiredRedirectURL: cfg.ExpiredRedirectURL,
+		batchMaxURLs:       batchMaxURLs,
+		redirectType:       redirectType,
+		redirectMaxAge:     redirectMaxAge,
 	}
 }
 
 type shortenPostRequest struct {
-	Url string `json:"url" validate:"required,url"`
+	Url       string     `json:"url" validate:"required,url"`
+	Alias     string     `json:"alias"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxClicks *int       `json:"max_clicks"`
+	Password  string     `json:"password"`
+	UTM       UTM        `json:"utm"`
+	Tags      []string   `json:"tags"`
+	// RedirectType is 301, 302, 307 or 308, zero for the configured default.
+	RedirectType int `json:"redirect_type"`
+}
+
+type unlockRequest struct {
+	Password string `json:"password" form:"password"`
+}
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,20}$`)
+
+func (r *shortenPostRequest) params(owner string) CreateShortTokenParams {
+	return CreateShortTokenParams{
+		Original:  r.Url,
+		Alias:     r.Alias,
+		ExpiresAt: r.ExpiresAt,
+		MaxClicks: r.MaxClicks,
+		Owner:     owner,
+		Password:  r.Password,
+		UTM:       r.UTM,
+		Tags:      r.Tags,
+
+		RedirectType: r.RedirectType,
+	}
+}
+
+type updateDestinationRequest struct {
+	Url string `json:"url"`
+}
+
+type shortenBatchRequest struct {
+	Items []shortenPostRequest `json:"items"`
+}
+
+type shortenBatchResult struct {
+	Index      int           `json:"index"`
+	ShortToken string        `json:"short_token,omitempty"`
+	Original   string        `json:"original,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Code       apperror.Code `json:"code,omitempty"`
+}
+
+func (r *shortenBatchResult) fail(err error) {
+	r.Error = err.Error()
+	r.Code = apperror.CodeOf(err)
+}
+
+var errURLRequired = apperror.Field(apperror.CodeInvalidRequest, "url", "URL field is required")
+
+func invalidJSON(err error) error {
+	return apperror.New(apperror.CodeInvalidRequest, "request body is not valid JSON: "+err.Error())
 }
 
 func validateShortenRequest(c *fiber.Ctx, req *shortenPostRequest) error {
 	if err := c.BodyParser(req); err != nil {
-		return errors.New("request body is not valid JSON: " + err.Error())
+		return invalidJSON(err)
 	}
 
 	if req.Url == "" {
-		return errors.New("URL field is required")
+		return errURLRequired
+	}
+
+	return nil
+}
+
+func validateShortenBatchRequest(c *fiber.Ctx, req *shortenBatchRequest, maxURLs int) error {
+	if err := c.BodyParser(req); err != nil {
+		return invalidJSON(err)
+	}
+
+	if len(req.Items) == 0 {
+		return apperror.Field(apperror.CodeInvalidRequest, "items", "items field is required")
+	}
+
+	if len(req.Items) > maxURLs {
+		return apperror.Field(apperror.CodeInvalidRequest, "items", fmt.Sprintf("at most %d items are allowed per batch", maxURLs))
 	}
 
 	return nil
 }
 func validateShortenRule(c *fiber.Ctx, req *shortenPostRequest) error {
 	if !govalidator.IsURL(req.Url) {
-		return errors.New("please provide a valid URL")
+		return apperror.Field(apperror.CodeInvalidURL, "url", "please provide a valid URL")
 	}
 
 	isOurDomain, err := helpers.OurDomainValidator(c.Hostname(), req.Url)
 	if err != nil {
-		return errors.New("unable to validate URL domain: " + err.Error())
+		return apperror.Field(apperror.CodeInvalidURL, "url", "unable to validate URL domain: "+err.Error())
 	}
 	if isOurDomain {
-		return errors.New("cannot create short URLs for this domain")
+		return apperror.Field(apperror.CodeOwnDomain, "url", "cannot create short URLs for this domain")
+	}
+
+	if req.Alias != "" {
+		if err := validateAlias(req.Alias); err != nil {
+			return err
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return apperror.Field(apperror.CodeValidation, "expires_at", "expires_at must be in the future")
+	}
+
+	if req.MaxClicks != nil && *req.MaxClicks < 1 {
+		return apperror.Field(apperror.CodeValidation, "max_clicks", "max_clicks must be at least 1")
+	}
+
+	// bcrypt ignores anything past 72 bytes
+	if req.Password != "" && (len(req.Password) < 4 || len(req.Password) > 72) {
+		return apperror.Field(apperror.CodeValidation, "password", "password must be between 4 and 72 bytes")
 	}
 
+	if req.RedirectType != 0 && !validRedirectType(req.RedirectType) {
+		return apperror.Field(apperror.CodeValidation, "redirect_type", "redirect_type must be 301, 302, 307 or 308")
+	}
+
+	if err := validateTags(req.Tags); err != nil {
+		return err
+	}
+
+	return validateUTM(req.UTM)
+}
+
+func validateTags(tags []string) error {
+	if len(tags) > maxTags {
+		return apperror.Field(apperror.CodeValidation, "tags", fmt.Sprintf("at most %d tags are allowed", maxTags))
+	}
+	for _, tag := range tags {
+		if !tagPattern.MatchString(strings.ToLower(strings.TrimSpace(tag))) {
+			return apperror.Field(apperror.CodeValidation, "tags", "tags "+tagRule)
+		}
+	}
+	return nil
+}
+
+func validRedirectType(status int) bool {
+	switch status {
+	case fiber.StatusMovedPermanently, fiber.StatusFound, fiber.StatusTemporaryRedirect, fiber.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// maxUTMLength matches the size of the utm_* columns.
+const maxUTMLength = 255
+
+func validateUTM(utm UTM) error {
+	for name, value := range utm.queryParams() {
+		if len(value) > maxUTMLength {
+			field := strings.TrimPrefix(name, "utm_")
+			return apperror.Field(apperror.CodeValidation, "utm."+field, fmt.Sprintf("utm %s must be at most %d bytes", field, maxUTMLength))
+		}
+	}
+	return nil
+}
+
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return apperror.Field(apperror.CodeValidation, "alias", "alias must be 3-20 characters of letters, digits, '-' or '_'")
+	}
+	if isReservedToken(alias) {
+		return apperror.Field(apperror.CodeValidation, "alias", "alias is reserved")
+	}
 	return nil
 }
 
 func (h *urlHandler) Create(c *fiber.Ctx) error {
 	req := new(shortenPostRequest)
 	if err := validateShortenRequest(c, req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(
-			response.ErrorPayload(response.ErrorResponseParams{
-				Message: "Invalid request format",
-				Err:     err.Error(),
-			}),
-		)
+		return err
 	}
 
 	if err := validateShortenRule(c, req); err != nil {
-		return c.Status(fiber.StatusUnprocessableEntity).JSON(
-			response.ErrorPayload(response.ErrorResponseParams{
-				Message: "URL validation failed",
-				Err:     err.Error(),
-			}),
-		)
+		return err
 	}
 
-	url, err := h.service.CreateShortToken(req.Url)
+	url, err := h.service.CreateShortToken(req.params(middleware.Owner(c)))
 	if err != nil {
-		return c.Status(fiber.StatusUnprocessableEntity).JSON(
-			response.ErrorPayload(response.ErrorResponseParams{
-				Message: "Unable to create short URL",
-				Err:     err.Error(),
-			}),
-		)
+		return err
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(response.SuccessPayload(response.SuccessPayloadParams{
@@ -92,21 +267,157 @@ func (h *urlHandler) Create(c *fiber.Ctx) error {
 	}))
 }
 
+func (h *urlHandler) CreateBatch(c *fiber.Ctx) error {
+	req := new(shortenBatchRequest)
+	if err := validateShortenBatchRequest(c, req, h.batchMaxURLs); err != nil {
+		return err
+	}
+
+	results := make([]shortenBatchResult, len(req.Items))
+	var params []CreateShortTokenParams
+	var indexes []int
+	for i := range req.Items {
+		item := &req.Items[i]
+		results[i].Index = i
+
+		if item.Url == "" {
+			results[i].fail(errURLRequired)
+			continue
+		}
+		if err := validateShortenRule(c, item); err != nil {
+			results[i].fail(err)
+			continue
+		}
+
+		params = append(params, item.params(middleware.Owner(c)))
+		indexes = append(indexes, i)
+	}
+
+	if len(params) > 0 {
+		created, err := h.service.CreateShortTokens(params)
+		if err != nil {
+			return err
+		}
+
+		for j, result := range created {
+			i := indexes[j]
+			if result.Err != nil {
+				results[i].fail(result.Err)
+				continue
+			}
+			results[i].ShortToken = result.URL.ShortToken
+			results[i].Original = result.URL.Original
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Batch processed",
+		Data:    results,
+	}))
+}
+
 func (h *urlHandler) FindByShortToken(c *fiber.Ctx) error {
 	shortToken := c.Params("shortToken")
 
 	url, err := h.service.FindByShortToken(shortToken)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(
-			response.ErrorPayload(response.ErrorResponseParams{
-				Message: "Short URL not found",
-				Err:     err.Error(),
-			}),
-		)
+		return err
+	}
+
+	params, err := breakdownParams(c, url)
+	if err != nil {
+		return err
+	}
+
+	breakdowns, err := h.service.ClickBreakdowns(params)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "URL retrieved successfully",
+		Data:    URLStats{URLModel: url.visibleTo(middleware.Owner(c)), Breakdowns: breakdowns},
+	}))
+}
+
+func (h *urlHandler) FindWithHistory(c *fiber.Ctx) error {
+	shortToken := c.Params("shortToken")
+
+	url, err := h.service.FindWithHistory(shortToken)
+	if err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
 		Message: "URL retrieved successfully",
+		Data:    url.visibleTo(middleware.Owner(c)),
+	}))
+}
+
+// errAPIKeyRequired answers owner-only requests made without an API key.
+var errAPIKeyRequired = apperror.New(apperror.CodeUnauthorized, "API key required, only the owner of a short URL can change it")
+
+func (h *urlHandler) UpdateDestination(c *fiber.Ctx) error {
+	owner := middleware.Owner(c)
+	if owner == "" {
+		return errAPIKeyRequired
+	}
+
+	req := new(updateDestinationRequest)
+	if err := c.BodyParser(req); err != nil {
+		return invalidJSON(err)
+	}
+	if req.Url == "" {
+		return errURLRequired
+	}
+
+	if err := validateShortenRule(c, &shortenPostRequest{Url: req.Url}); err != nil {
+		return err
+	}
+
+	url, err := h.service.UpdateDestination(UpdateDestinationParams{
+		ShortToken: c.Params("shortToken"),
+		Original:   req.Url,
+		Actor:      owner,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Short URL updated successfully",
+		Data:    url,
+	}))
+}
+
+func (h *urlHandler) Delete(c *fiber.Ctx) error {
+	owner := middleware.Owner(c)
+	if owner == "" {
+		return errAPIKeyRequired
+	}
+
+	if err := h.service.Delete(c.Params("shortToken"), owner); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Short URL deleted successfully",
+	}))
+}
+
+func (h *urlHandler) Restore(c *fiber.Ctx) error {
+	owner := middleware.Owner(c)
+	if owner == "" {
+		return errAPIKeyRequired
+	}
+
+	url, err := h.service.Restore(c.Params("shortToken"), owner)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Short URL restored successfully",
 		Data:    url,
 	}))
 }
@@ -114,15 +425,126 @@ func (h *urlHandler) FindByShortToken(c *fiber.Ctx) error {
 func (h *urlHandler) RedirectToOriginal(c *fiber.Ctx) error {
 	shortToken := c.Params("shortToken")
 
-	url, err := h.service.RedirectService(shortToken)
+	url, err := h.service.RedirectService(shortToken, clickParams(c))
+	if errors.Is(err, ErrPasswordNeeded) {
+		return renderPasswordForm(c, fiber.StatusOK, passwordFormData{ShortToken: shortToken})
+	}
+	if err != nil {
+		return h.redirectError(c, err)
+	}
+
+	return h.redirect(c, url)
+}
+
+// HeadRedirect answers HEAD /:shortToken like a redirect, without counting a
+// click, for link checkers.
+func (h *urlHandler) HeadRedirect(c *fiber.Ctx) error {
+	shortToken := c.Params("shortToken")
+
+	url, err := h.service.ResolveService(shortToken)
+	if errors.Is(err, ErrPasswordNeeded) {
+		return renderPasswordForm(c, fiber.StatusOK, passwordFormData{ShortToken: shortToken})
+	}
+	if err != nil {
+		return h.redirectError(c, err)
+	}
+
+	return h.redirect(c, url)
+}
+
+// Preview shows where a short URL leads without redirecting, as HTML for
+// browsers and JSON otherwise.
+func (h *urlHandler) Preview(c *fiber.Ctx) error {
+	preview, err := h.service.PreviewService(c.Params("shortToken"))
+	if err != nil {
+		return err
+	}
+
+	// the click count changes with every visit
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextHTML) == fiber.MIMETextHTML {
+		return renderPreview(c, preview)
+	}
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Preview retrieved successfully",
+		Data:    preview,
+	}))
+}
+
+func (h *urlHandler) Unlock(c *fiber.Ctx) error {
+	shortToken := c.Params("shortToken")
+
+	req := new(unlockRequest)
+	if err := c.BodyParser(req); err != nil {
+		return renderPasswordForm(c, fiber.StatusBadRequest, passwordFormData{
+			ShortToken: shortToken,
+			Error:      "Please enter the password.",
+		})
+	}
+
+	url, err := h.service.UnlockService(shortToken, req.Password, clickParams(c))
+	if errors.Is(err, ErrWrongPassword) {
+		return renderPasswordForm(c, fiber.StatusForbidden, passwordFormData{
+			ShortToken: shortToken,
+			Error:      "Incorrect password, please try again.",
+		})
+	}
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(
-			response.ErrorPayload(response.ErrorResponseParams{
-				Message: "Short URL not found",
-				Err:     err.Error(),
-			}),
-		)
+		return h.redirectError(c, err)
 	}
 
+	// a 307 or 308 would repeat the password POST to the destination
+	c.Set(fiber.HeaderCacheControl, "no-store")
 	return c.Redirect(url.Original, fiber.StatusFound)
 }
+
+func clickParams(c *fiber.Ctx) ClickParams {
+	return ClickParams{
+		Referrer:       c.Get(fiber.HeaderReferer),
+		UserAgent:      c.Get(fiber.HeaderUserAgent),
+		IP:             c.IP(),
+		AcceptLanguage: c.Get(fiber.HeaderAcceptLanguage),
+		Prefetch:       isPrefetch(c),
+	}
+}
+
+// isPrefetch reports whether the request was made ahead of an actual visit
+// by a browser or proxy.
+func isPrefetch(c *fiber.Ctx) bool {
+	for _, header := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		value := strings.ToLower(c.Get(header))
+		if strings.Contains(value, "prefetch") || strings.Contains(value, "preview") {
+			return true
+		}
+	}
+	return false
+}
+
+// redirect sends the visitor to url with its redirect type. Permanent
+// redirects of links without limits or a password may be cached; every other
+// redirect must reach the server again so that it is checked and counted.
+func (h *urlHandler) redirect(c *fiber.Ctx, url *URLModel) error {
+	status := url.RedirectType
+	if status == 0 {
+		status = h.redirectType
+	}
+
+	permanent := status == fiber.StatusMovedPermanently || status == fiber.StatusPermanentRedirect
+	if permanent && url.ExpiresAt == nil && url.MaxClicks == nil && !url.IsProtected() {
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(h.redirectMaxAge.Seconds())))
+	} else {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+	}
+	return c.Redirect(url.Original, status)
+}
+
+// redirectError answers a failed redirect or unlock. Expired links go to the
+// configured expired redirect, if any; other errors are left to the
+// ErrorHandler.
+func (h *urlHandler) redirectError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, ErrLinkExpired) && h.expiredRedirectURL != "" {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Redirect(h.expiredRedirectURL, fiber.StatusFound)
+	}
+	return err
+}
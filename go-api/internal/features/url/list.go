@@ -0,0 +1,202 @@
+package url
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+)
+
+// ListSort is the column links are listed by.
+type ListSort string
+
+const (
+	ListByCreatedAt  ListSort = "created_at"
+	ListByClickCount ListSort = "click_count"
+)
+
+func (s ListSort) Valid() bool {
+	return s == ListByCreatedAt || s == ListByClickCount
+}
+
+// ListState filters links by whether they still redirect.
+type ListState string
+
+const (
+	ListAll     ListState = ""
+	ListActive  ListState = "active"
+	ListExpired ListState = "expired"
+)
+
+func (s ListState) Valid() bool {
+	return s == ListAll || s == ListActive || s == ListExpired
+}
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+var ErrInvalidListQuery = apperror.New(apperror.CodeInvalidRequest, "invalid list query")
+
+var domainPattern = regexp.MustCompile(`^[a-z0-9.-]+$`)
+
+type ListParams struct {
+	Owner string
+	// From and To bound created_at, From inclusive and To exclusive.
+	From *time.Time
+	To   *time.Time
+	// Domain matches links whose destination host is Domain or one of its
+	// subdomains.
+	Domain string
+	Tag    string
+	State  ListState
+	// Sort defaults to ListByCreatedAt, newest first.
+	Sort ListSort
+	// Ascending lists the oldest or least clicked links first.
+	Ascending bool
+	// Limit is the page size. Zero means 20.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first one.
+	Cursor string
+}
+
+// URLListQuery is a validated ListParams as read by URLRepo.ListURLs.
+type URLListQuery struct {
+	Owner     string
+	From      *time.Time
+	To        *time.Time
+	Domain    string
+	Tag       string
+	State     ListState
+	Sort      ListSort
+	Ascending bool
+	// After is the position of the last link of the previous page, nil for
+	// the first one.
+	After *ListCursor
+	Limit int
+	// Now decides which links count as expired.
+	Now time.Time
+}
+
+// ListCursor is the position of a link in a listing. Clients only see it as
+// an opaque string.
+type ListCursor struct {
+	Sort       ListSort  `json:"s"`
+	Ascending  bool      `json:"a,omitempty"`
+	CreatedAt  time.Time `json:"t"`
+	ClickCount int       `json:"c"`
+	ID         uint      `json:"i"`
+}
+
+func (c ListCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(value string) (*ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor ListCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// URLPage is one page of a listing.
+type URLPage struct {
+	Items []*URLModel `json:"items"`
+	// NextCursor fetches the following page. It is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListURLs returns a page of the links owned by params.Owner. Pages are
+// keyed on the sort column and id, so links created or deleted while paging
+// never shift the following pages. Click counts keep changing, so a listing
+// by click count is only a snapshot per page.
+func (s *urlService) ListURLs(params ListParams) (*URLPage, error) {
+	if params.Owner == "" {
+		return nil, fmt.Errorf("%w: an owner is required", ErrInvalidListQuery)
+	}
+
+	query := URLListQuery{
+		Owner:     params.Owner,
+		From:      params.From,
+		To:        params.To,
+		Domain:    strings.ToLower(strings.TrimSpace(params.Domain)),
+		Tag:       strings.ToLower(strings.TrimSpace(params.Tag)),
+		State:     params.State,
+		Sort:      params.Sort,
+		Ascending: params.Ascending,
+		Limit:     params.Limit,
+		Now:       time.Now(),
+	}
+	if query.Sort == "" {
+		query.Sort = ListByCreatedAt
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultListLimit
+	}
+
+	if !query.Sort.Valid() {
+		return nil, fmt.Errorf("%w: sort must be created_at or click_count", ErrInvalidListQuery)
+	}
+	if !query.State.Valid() {
+		return nil, fmt.Errorf("%w: state must be active or expired", ErrInvalidListQuery)
+	}
+	if query.Limit < 1 || query.Limit > maxListLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, maxListLimit)
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidListQuery)
+	}
+	if query.Domain != "" && !domainPattern.MatchString(query.Domain) {
+		return nil, fmt.Errorf("%w: domain must be a host name such as example.com", ErrInvalidListQuery)
+	}
+	if query.Tag != "" && !tagPattern.MatchString(query.Tag) {
+		return nil, fmt.Errorf("%w: tag %s", ErrInvalidListQuery, tagRule)
+	}
+
+	if params.Cursor != "" {
+		cursor, err := decodeListCursor(params.Cursor)
+		if err != nil {
+			return nil, fmt.Errorf("%w: cursor is not valid", ErrInvalidListQuery)
+		}
+		if cursor.Sort != query.Sort || cursor.Ascending != query.Ascending {
+			return nil, fmt.Errorf("%w: cursor belongs to a listing with another sort order", ErrInvalidListQuery)
+		}
+		query.After = cursor
+	}
+
+	// one extra link tells whether another page follows
+	limit := query.Limit
+	query.Limit++
+	urls, err := s.repo.ListURLs(query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &URLPage{Items: urls}
+	if len(urls) > limit {
+		page.Items = urls[:limit]
+		last := urls[limit-1]
+		page.NextCursor = ListCursor{
+			Sort:       query.Sort,
+			Ascending:  query.Ascending,
+			CreatedAt:  last.CreatedAt,
+			ClickCount: last.ClickCount,
+			ID:         last.ID,
+		}.encode()
+	}
+	if page.Items == nil {
+		page.Items = []*URLModel{}
+	}
+	return page, nil
+}
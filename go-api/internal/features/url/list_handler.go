@@ -0,0 +1,78 @@
+package url
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/common/middleware"
+	"github.com/nabilfikrisp/url-shortener/internal/common/response"
+)
+
+// listParams reads the from, to, tz, domain, tag, state, sort, order, limit
+// and cursor query parameters of GET /urls.
+func listParams(c *fiber.Ctx, owner string) (ListParams, error) {
+	params := ListParams{
+		Owner:  owner,
+		Domain: c.Query("domain"),
+		Tag:    c.Query("tag"),
+		Cursor: c.Query("cursor"),
+	}
+
+	from, to, _, err := parseStatsQuery(c)
+	if err != nil {
+		return params, err
+	}
+	params.From, params.To = from, to
+
+	params.State = ListState(c.Query("state"))
+	if !params.State.Valid() {
+		return params, apperror.Field(apperror.CodeInvalidRequest, "state", "state must be active or expired")
+	}
+
+	params.Sort = ListSort(c.Query("sort", string(ListByCreatedAt)))
+	if !params.Sort.Valid() {
+		return params, apperror.Field(apperror.CodeInvalidRequest, "sort", "sort must be created_at or click_count")
+	}
+
+	switch c.Query("order", "desc") {
+	case "asc":
+		params.Ascending = true
+	case "desc":
+	default:
+		return params, apperror.Field(apperror.CodeInvalidRequest, "order", "order must be asc or desc")
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return params, apperror.Field(apperror.CodeInvalidRequest, "limit", fmt.Sprintf("limit must be a number between 1 and %d", maxListLimit))
+		}
+		params.Limit = n
+	}
+	return params, nil
+}
+
+// List pages through the short URLs owned by the caller.
+func (h *urlHandler) List(c *fiber.Ctx) error {
+	owner := middleware.Owner(c)
+	if owner == "" {
+		return apperror.New(apperror.CodeUnauthorized, "API key required, links are listed for the owner of the X-API-Key")
+	}
+
+	params, err := listParams(c, owner)
+	if err != nil {
+		return err
+	}
+
+	page, err := h.service.ListURLs(params)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "URLs retrieved successfully",
+		Data:    page,
+	}))
+}
@@ -0,0 +1,33 @@
+package url
+
+import "gorm.io/gorm"
+
+// shortTokenSequence feeds the sequence and hashids token strategies.
+const shortTokenSequence = "short_token_seq"
+
+// Models lists every table owned by the url feature.
+func Models() []any {
+	return []any{
+		&URLModel{},
+		&URLDestinationHistory{},
+		&ClickEvent{},
+		&VisitorSketch{},
+		&VisitorSalt{},
+	}
+}
+
+// Migrate creates or updates the tables and sequences used by the url feature.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(Models()...); err != nil {
+		return err
+	}
+
+	// links created before the domain column existed
+	backfill := `UPDATE urls SET domain = lower(substring(original from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^@/?#]*@)?([^:/?#]+)'))
+		WHERE domain IS NULL OR domain = ''`
+	if err := db.Exec(backfill).Error; err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE SEQUENCE IF NOT EXISTS " + shortTokenSequence).Error
+}
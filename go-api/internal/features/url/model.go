@@ -1,22 +1,232 @@
 package url
 
 import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 // URL represents the mapping between the original long URL and its short token.
+//
+// The idx_urls_owner_* indexes back the filters and keyset pagination of
+// ListURLs.
 type URLModel struct {
-	ID         uint           `gorm:"primaryKey" json:"id"`
-	ShortToken string         `gorm:"uniqueIndex;size:20;not null" json:"short_token"`
-	Original   string         `gorm:"not null" json:"original"`
-	ClickCount int            `gorm:"default:0" json:"click_count"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	ID          uint   `gorm:"primaryKey;index:idx_urls_owner_created_at,priority:3;index:idx_urls_owner_click_count,priority:3" json:"id"`
+	ShortToken  string `gorm:"uniqueIndex;size:20;not null" json:"short_token"`
+	Original    string `gorm:"not null" json:"original"`
+	RawOriginal string `json:"raw_original,omitempty"`
+	// Domain is the lowercased host of Original, kept to filter by.
+	Domain        string         `gorm:"size:255;index:idx_urls_owner_domain,priority:2" json:"-"`
+	ClickCount    int            `gorm:"default:0;index:idx_urls_owner_click_count,priority:2" json:"click_count"`
+	BotClickCount int            `gorm:"default:0" json:"bot_click_count"`
+	ExpiresAt     *time.Time     `json:"expires_at,omitempty"`
+	MaxClicks     *int           `json:"max_clicks,omitempty"`
+	RedirectType  int            `gorm:"default:0" json:"redirect_type,omitempty"`
+	Owner         string         `gorm:"index;index:idx_urls_owner_created_at,priority:1;index:idx_urls_owner_click_count,priority:1;index:idx_urls_owner_domain,priority:1;size:64" json:"owner,omitempty"`
+	PasswordHash  string         `gorm:"size:72" json:"-"`
+	UTM           UTM            `gorm:"embedded;embeddedPrefix:utm_" json:"utm,omitzero"`
+	Tags          Tags           `gorm:"type:text[];index:idx_urls_tags,type:gin" json:"tags,omitempty"`
+	CreatedAt     time.Time      `gorm:"index:idx_urls_owner_created_at,priority:2" json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+
+	History  []URLDestinationHistory `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE" json:"history,omitempty"`
+	Clicks   []ClickEvent            `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE" json:"-"`
+	Visitors []VisitorSketch         `gorm:"foreignKey:URLID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
 func (URLModel) TableName() string {
 	return "urls"
 }
+
+// UTM holds the campaign parameters merged into a link's destination when it
+// was created, kept as columns so clicks can be grouped by campaign.
+type UTM struct {
+	Source   string `gorm:"size:255" json:"source,omitempty"`
+	Medium   string `gorm:"size:255" json:"medium,omitempty"`
+	Campaign string `gorm:"size:255;index" json:"campaign,omitempty"`
+	Term     string `gorm:"size:255" json:"term,omitempty"`
+	Content  string `gorm:"size:255" json:"content,omitempty"`
+}
+
+// queryParams returns the UTM fields keyed by their query parameter names.
+func (u UTM) queryParams() map[string]string {
+	return map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_term":     u.Term,
+		"utm_content":  u.Content,
+	}
+}
+
+func (u UTM) trimmed() UTM {
+	return UTM{
+		Source:   strings.TrimSpace(u.Source),
+		Medium:   strings.TrimSpace(u.Medium),
+		Campaign: strings.TrimSpace(u.Campaign),
+		Term:     strings.TrimSpace(u.Term),
+		Content:  strings.TrimSpace(u.Content),
+	}
+}
+
+// Tags label a link so its owner can group and filter links. They are stored
+// as a Postgres text[]; tag names are limited to characters that never need
+// quoting in an array literal.
+type Tags []string
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// tagRule describes tagPattern in error messages.
+const tagRule = "must be 1-32 lowercase letters, digits, '-' or '_'"
+
+// maxTags caps how many tags one link may carry.
+const maxTags = 10
+
+// normalizeTags lowercases, trims, dedupes and sorts tags.
+func normalizeTags(tags []string) Tags {
+	var normalized Tags
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized
+}
+
+func (t Tags) Value() (driver.Value, error) {
+	return "{" + strings.Join(t, ",") + "}", nil
+}
+
+func (t *Tags) Scan(src any) error {
+	var literal string
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		literal = v
+	case []byte:
+		literal = string(v)
+	default:
+		return fmt.Errorf("unable to scan %T into Tags", src)
+	}
+
+	literal = strings.TrimSuffix(strings.TrimPrefix(literal, "{"), "}")
+	if literal == "" {
+		*t = nil
+		return nil
+	}
+	*t = strings.Split(literal, ",")
+	return nil
+}
+
+// IsProtected reports whether the link requires a password before redirecting.
+func (u *URLModel) IsProtected() bool {
+	return u.PasswordHash != ""
+}
+
+// OwnedBy reports whether actor owns the link. Anonymous links have no owner.
+func (u *URLModel) OwnedBy(actor string) bool {
+	return u.Owner != "" && u.Owner == actor
+}
+
+// visibleTo returns the link as shown to actor. Only the owner sees who owns
+// it and where it pointed before, and, for a protected link, where it points.
+func (u *URLModel) visibleTo(actor string) *URLModel {
+	if u.OwnedBy(actor) {
+		return u
+	}
+	view := *u
+	view.Owner = ""
+	view.History = nil
+	if u.IsProtected() {
+		view.Original, view.RawOriginal = "", ""
+	}
+	return &view
+}
+
+// URLDestinationHistory keeps a destination a short URL pointed to before it
+// was retargeted, along with who changed it and when.
+type URLDestinationHistory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	URLID     uint      `gorm:"index;not null" json:"-"`
+	Original  string    `gorm:"not null" json:"original"`
+	ChangedBy string    `gorm:"size:64" json:"changed_by"`
+	ChangedAt time.Time `gorm:"not null" json:"changed_at"`
+}
+
+func (URLDestinationHistory) TableName() string {
+	return "url_destination_history"
+}
+
+// ClickEvent records a single redirect. The client IP is only stored hashed.
+type ClickEvent struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	URLID          uint      `gorm:"index:idx_click_events_url_time;not null" json:"-"`
+	OccurredAt     time.Time `gorm:"index:idx_click_events_url_time;not null" json:"occurred_at"`
+	Referrer       string    `json:"referrer,omitempty"`
+	ReferrerSource string    `gorm:"size:255" json:"referrer_source,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	IPHash         string    `gorm:"size:64" json:"ip_hash,omitempty"`
+	AcceptLanguage string    `json:"accept_language,omitempty"`
+	Browser        string    `gorm:"size:64" json:"browser,omitempty"`
+	OS             string    `gorm:"size:64" json:"os,omitempty"`
+	Device         string    `gorm:"size:16" json:"device,omitempty"`
+	Country        string    `gorm:"size:2" json:"country,omitempty"`
+	City           string    `gorm:"size:128" json:"city,omitempty"`
+	IsBot          bool      `gorm:"not null;default:false" json:"is_bot"`
+	Visitor        uint64    `gorm:"-" json:"-"`
+}
+
+func (ClickEvent) TableName() string {
+	return "click_events"
+}
+
+// VisitorSketch is the HyperLogLog sketch of the visitors of a URL on one UTC
+// day.
+type VisitorSketch struct {
+	URLID  uint      `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Day    time.Time `gorm:"primaryKey;type:date" json:"day"`
+	Sketch []byte    `gorm:"not null" json:"-"`
+}
+
+func (VisitorSketch) TableName() string {
+	return "visitor_sketches"
+}
+
+// visitorDay is the UTC day visitor sketches and salts are kept per.
+func visitorDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// VisitorSalt is the random salt of visitor fingerprints for one UTC day. Old
+// salts are deleted so fingerprints cannot be recomputed later.
+type VisitorSalt struct {
+	Day  time.Time `gorm:"primaryKey;type:date"`
+	Salt []byte    `gorm:"not null"`
+}
+
+func (VisitorSalt) TableName() string {
+	return "visitor_salts"
+}
+
+// IsExpired reports whether the link has passed its expiry date or used up its
+// click limit.
+func (u *URLModel) IsExpired(now time.Time) bool {
+	if u.ExpiresAt != nil && !now.Before(*u.ExpiresAt) {
+		return true
+	}
+	if u.MaxClicks != nil && u.ClickCount >= *u.MaxClicks {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package url
+
+import (
+	"html/template"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var passwordFormTemplate = template.Must(template.New("password_form").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; justify-content: center; margin-top: 15vh; }
+form { display: flex; flex-direction: column; gap: .75rem; width: 18rem; }
+input, button { font-size: 1rem; padding: .5rem; }
+.error { color: #b00020; margin: 0; }
+</style>
+</head>
+<body>
+<form method="POST" action="/{{.ShortToken}}">
+<h1>Password required</h1>
+<p>This link is protected. Enter the password to continue.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<input type="password" name="password" autocomplete="current-password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+type passwordFormData struct {
+	ShortToken string
+	Error      string
+}
+
+func renderPasswordForm(c *fiber.Ctx, status int, data passwordFormData) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Status(status)
+	return passwordFormTemplate.Execute(c.Response().BodyWriter(), data)
+}
@@ -0,0 +1,76 @@
+package url
+
+import (
+	"html/template"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Preview describes where a short URL leads without following it.
+type Preview struct {
+	ShortToken string `json:"short_token"`
+	// Original is empty for password protected links, whose destination is
+	// only revealed by unlocking them.
+	Original   string     `json:"original,omitempty"`
+	Protected  bool       `json:"protected"`
+	Expired    bool       `json:"expired"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	ClickCount int        `json:"click_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// PreviewService describes a short URL, including expired ones, without
+// counting a click.
+func (s *urlService) PreviewService(shortToken string) (*Preview, error) {
+	url, err := s.FindByShortToken(shortToken)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &Preview{
+		ShortToken: url.ShortToken,
+		Protected:  url.IsProtected(),
+		Expired:    url.IsExpired(time.Now()),
+		ExpiresAt:  url.ExpiresAt,
+		ClickCount: url.ClickCount,
+		CreatedAt:  url.CreatedAt,
+	}
+	if !preview.Protected {
+		preview.Original = url.Original
+	}
+	return preview, nil
+}
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Link preview</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; justify-content: center; margin-top: 15vh; }
+main { display: flex; flex-direction: column; gap: .75rem; width: 32rem; max-width: 90vw; }
+code { word-break: break-all; background: #f2f2f2; padding: .5rem; }
+.notice { color: #b00020; margin: 0; }
+</style>
+</head>
+<body>
+<main>
+<h1>Link preview</h1>
+{{if .Protected}}<p>This link is password protected. Its destination is shown after entering the password.</p>
+{{else}}<p>This link leads to:</p>
+<code>{{.Original}}</code>
+{{end}}<p>Created {{.CreatedAt.UTC.Format "2 January 2006"}} &middot; clicked {{.ClickCount}} times</p>
+{{if .Expired}}<p class="notice">This link has expired.</p>
+{{else}}<a href="/{{.ShortToken}}">Continue</a>
+{{end}}</main>
+</body>
+</html>
+`))
+
+func renderPreview(c *fiber.Ctx, preview *Preview) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return previewTemplate.Execute(c.Response().BodyWriter(), preview)
+}
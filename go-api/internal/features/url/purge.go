@@ -0,0 +1,44 @@
+package url
+
+import (
+	"log"
+	"time"
+
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+	"gorm.io/gorm"
+)
+
+// StartPurger hard-deletes URLs soft-deleted longer than cfg.PurgeAfter, once
+// at startup and then every cfg.PurgeInterval. Calling the returned function
+// stops it. Purging is disabled when cfg.PurgeAfter is zero.
+func StartPurger(db *gorm.DB, cfg *config.Config) (stop func()) {
+	if cfg.PurgeAfter <= 0 || cfg.PurgeInterval <= 0 {
+		return func() {}
+	}
+
+	repo := NewURLRepo(db)
+	service := NewURLService(repo, NewTokenGenerator(cfg, repo), nil, nil, cfg)
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cfg.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purged, err := service.PurgeDeleted(cfg.PurgeAfter)
+			if err != nil {
+				log.Printf("purge deleted URLs: %v", err)
+			} else if purged > 0 {
+				log.Printf("purged %d deleted URLs", purged)
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
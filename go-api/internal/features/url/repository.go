@@ -1,15 +1,45 @@
 package url
 
 import (
+	"crypto/rand"
+	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/common/hll"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrShortTokenTaken is returned by Create when the token is already stored,
+// including by a soft-deleted URL.
+var ErrShortTokenTaken = apperror.New(apperror.CodeConflict, "short token is already taken")
+
 type URLRepo interface {
 	Create(url *URLModel) error
+	CreateBatch(urls []*URLModel) error
 	FindByShortToken(shortToken string) (*URLModel, error)
-	IncrementClickCount(shortToken string) (int64, error)
+	FindByShortTokens(shortTokens []string) ([]*URLModel, error)
+	FindByShortTokenWithHistory(shortToken string) (*URLModel, error)
+	ListURLs(query URLListQuery) ([]*URLModel, error)
+	UpdateDestination(url *URLModel, history *URLDestinationHistory) error
+	SoftDelete(url *URLModel) error
+	FindDeletedByShortToken(shortToken string) (*URLModel, error)
+	Restore(url *URLModel) error
+	PurgeDeleted(before time.Time) (int64, error)
+	RecordClick(event *ClickEvent) (int64, error)
+	RecordClicks(events []*ClickEvent) error
+	FindVisitorSketches(urlID uint, from time.Time, to time.Time) ([]VisitorSketch, error)
+	DailySalt(day time.Time) ([]byte, error)
+	CountClicksByBucket(params ClickBucketParams) ([]ClickBucketCount, error)
+	CountClicks(params ClickRangeParams) (int64, error)
+	TopClickValues(params ClickTopParams) ([]BreakdownItem, error)
+	EachClickEvent(params ClickExportParams, fn func(ExportedClick) error) error
+	EachDailyClicks(params ClickExportParams, fn func(DailyClickCount) error) error
+	NextSequence() (uint64, error)
 }
 
 type urlRepo struct {
@@ -23,7 +53,21 @@ func NewURLRepo(db *gorm.DB) URLRepo {
 }
 
 func (r *urlRepo) Create(url *URLModel) error {
-	return r.db.Create(url).Error
+	if err := r.db.Create(url).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrShortTokenTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateBatch inserts all urls in one transaction; either all or none are
+// stored.
+func (r *urlRepo) CreateBatch(urls []*URLModel) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(urls, 100).Error
+	})
 }
 
 func (r *urlRepo) FindByShortToken(shortToken string) (*URLModel, error) {
@@ -41,15 +85,452 @@ func (r *urlRepo) FindByShortToken(shortToken string) (*URLModel, error) {
 	return &url, nil
 }
 
-func (r *urlRepo) IncrementClickCount(shortToken string) (int64, error) {
+// FindByShortTokens returns the URLs holding any of shortTokens, including
+// soft-deleted ones since their tokens are still taken.
+func (r *urlRepo) FindByShortTokens(shortTokens []string) ([]*URLModel, error) {
+	if len(shortTokens) == 0 {
+		return nil, nil
+	}
+
+	var urls []*URLModel
+	if err := r.db.Unscoped().Where("short_token IN ?", shortTokens).Find(&urls).Error; err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+func (r *urlRepo) FindByShortTokenWithHistory(shortToken string) (*URLModel, error) {
+	if shortToken == "" {
+		return nil, errors.New("short token is required")
+	}
+
+	var url URLModel
+	err := r.db.
+		Preload("History", func(db *gorm.DB) *gorm.DB {
+			return db.Order("changed_at DESC, id DESC")
+		}).
+		Where("short_token = ?", shortToken).
+		First(&url).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &url, nil
+}
+
+// ListURLs returns up to query.Limit links of query.Owner matching its
+// filters, ordered by the sort column and id and starting after query.After.
+func (r *urlRepo) ListURLs(query URLListQuery) ([]*URLModel, error) {
+	db := r.db.Where("owner = ?", query.Owner)
+	if query.From != nil {
+		db = db.Where("created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		db = db.Where("created_at < ?", *query.To)
+	}
+	if query.Domain != "" {
+		db = db.Where("(domain = ? OR domain LIKE ?)", query.Domain, "%."+query.Domain)
+	}
+	if query.Tag != "" {
+		db = db.Where("tags @> ?", Tags{query.Tag})
+	}
+
+	// mirrors URLModel.IsExpired
+	expired := "((expires_at IS NOT NULL AND expires_at <= @now) OR (max_clicks IS NOT NULL AND click_count >= max_clicks))"
+	switch query.State {
+	case ListActive:
+		db = db.Where("NOT "+expired, sql.Named("now", query.Now))
+	case ListExpired:
+		db = db.Where(expired, sql.Named("now", query.Now))
+	}
+
+	column := string(query.Sort)
+	direction, after := "DESC", "<"
+	if query.Ascending {
+		direction, after = "ASC", ">"
+	}
+	if query.After != nil {
+		var position any = query.After.CreatedAt
+		if query.Sort == ListByClickCount {
+			position = query.After.ClickCount
+		}
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, after), position, query.After.ID)
+	}
+
+	var urls []*URLModel
+	err := db.
+		Order(fmt.Sprintf("%s %s, id %s", column, direction, direction)).
+		Limit(query.Limit).
+		Find(&urls).Error
+	if err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// UpdateDestination saves the new destination of url and records the previous
+// one in the same transaction.
+func (r *urlRepo) UpdateDestination(url *URLModel, history *URLDestinationHistory) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(history).Error; err != nil {
+			return err
+		}
+		return tx.Model(url).Select("original", "raw_original", "domain").Updates(url).Error
+	})
+}
+
+func (r *urlRepo) SoftDelete(url *URLModel) error {
+	return r.db.Delete(url).Error
+}
+
+func (r *urlRepo) FindDeletedByShortToken(shortToken string) (*URLModel, error) {
 	if shortToken == "" {
-		return 0, errors.New("short token is required")
+		return nil, errors.New("short token is required")
 	}
 
-	result := r.db.Model(&URLModel{}).Where("short_token = ?", shortToken).UpdateColumn("click_count", gorm.Expr("click_count + 1"))
-	if result.RowsAffected == 0 {
-		return 0, gorm.ErrRecordNotFound
+	var url URLModel
+	err := r.db.Unscoped().
+		Where("short_token = ? AND deleted_at IS NOT NULL", shortToken).
+		First(&url).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
 	}
+	return &url, nil
+}
 
+func (r *urlRepo) Restore(url *URLModel) error {
+	if err := r.db.Unscoped().Model(url).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	url.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+// PurgeDeleted hard-deletes URLs soft-deleted before the given time.
+func (r *urlRepo) PurgeDeleted(before time.Time) (int64, error) {
+	result := r.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Delete(&URLModel{})
 	return result.RowsAffected, result.Error
 }
+
+// clickCountColumn is the URL counter a click event adds to.
+func clickCountColumn(event *ClickEvent) string {
+	if event.IsBot {
+		return "bot_click_count"
+	}
+	return "click_count"
+}
+
+// RecordClick stores the click event and bumps the URL's click_count, or
+// bot_click_count for bots, in one transaction. A human click on a URL that
+// reached max_clicks is refused with ErrLinkExpired, so concurrent redirects
+// cannot exceed the limit.
+func (r *urlRepo) RecordClick(event *ClickEvent) (int64, error) {
+	var affectedRows int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		column := clickCountColumn(event)
+		update := tx.Model(&URLModel{}).Where("id = ?", event.URLID)
+		if !event.IsBot {
+			update = update.Where("max_clicks IS NULL OR click_count < max_clicks")
+		}
+		result := update.UpdateColumn(column, gorm.Expr(column+" + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var count int64
+			if err := tx.Model(&URLModel{}).Where("id = ?", event.URLID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrLinkExpired
+			}
+			return gorm.ErrRecordNotFound
+		}
+		affectedRows = result.RowsAffected
+
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		return mergeVisitors(tx, []*ClickEvent{event})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return affectedRows, nil
+}
+
+// RecordClicks stores a batch of click events and adds them to each URL's
+// click_count and bot_click_count in one transaction. Events for URLs that no
+// longer exist are skipped.
+func (r *urlRepo) RecordClicks(events []*ClickEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	ids, deltas := clickDeltas(events)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		missing := make(map[uint]bool)
+		for _, id := range ids {
+			result := tx.Model(&URLModel{}).Unscoped().Where("id = ?", id).UpdateColumns(map[string]any{
+				"click_count":     gorm.Expr("click_count + ?", deltas[id].Human),
+				"bot_click_count": gorm.Expr("bot_click_count + ?", deltas[id].Bot),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				missing[id] = true
+			}
+		}
+
+		kept := make([]*ClickEvent, 0, len(events))
+		for _, event := range events {
+			if !missing[event.URLID] {
+				kept = append(kept, event)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(kept, 500).Error; err != nil {
+			return err
+		}
+		return mergeVisitors(tx, kept)
+	})
+}
+
+// mergeVisitors adds the visitors of human clicks to the daily sketch of their
+// URL. Each sketch row is locked while it is merged.
+func mergeVisitors(tx *gorm.DB, events []*ClickEvent) error {
+	type sketchKey struct {
+		urlID uint
+		day   time.Time
+	}
+
+	sketches := make(map[sketchKey]*hll.Sketch)
+	var keys []sketchKey
+	for _, event := range events {
+		if event.IsBot || event.Visitor == 0 {
+			continue
+		}
+		key := sketchKey{urlID: event.URLID, day: visitorDay(event.OccurredAt)}
+		sketch, ok := sketches[key]
+		if !ok {
+			sketch = hll.New()
+			sketches[key] = sketch
+			keys = append(keys, key)
+		}
+		sketch.Add(event.Visitor)
+	}
+
+	// lock rows in a fixed order so concurrent flushes cannot deadlock
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].urlID != keys[j].urlID {
+			return keys[i].urlID < keys[j].urlID
+		}
+		return keys[i].day.Before(keys[j].day)
+	})
+
+	empty, _ := hll.New().MarshalBinary()
+	for _, key := range keys {
+		row := VisitorSketch{URLID: key.urlID, Day: key.day, Sketch: empty}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return err
+		}
+
+		var stored VisitorSketch
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("url_id = ? AND day = ?", key.urlID, key.day).
+			Take(&stored).Error
+		if err != nil {
+			return err
+		}
+
+		sketch := sketches[key]
+		if existing, err := hll.FromBytes(stored.Sketch); err == nil {
+			sketch.Merge(existing)
+		}
+		data, _ := sketch.MarshalBinary()
+
+		err = tx.Model(&VisitorSketch{}).
+			Where("url_id = ? AND day = ?", key.urlID, key.day).
+			Update("sketch", data).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindVisitorSketches returns the daily sketches of a URL for days in
+// [from, to), oldest first.
+func (r *urlRepo) FindVisitorSketches(urlID uint, from time.Time, to time.Time) ([]VisitorSketch, error) {
+	var sketches []VisitorSketch
+	err := r.db.Where("url_id = ? AND day >= ? AND day < ?", urlID, from, to).
+		Order("day").
+		Find(&sketches).Error
+	return sketches, err
+}
+
+// DailySalt returns the visitor salt of day, creating it on first use. Salts
+// older than the day before are deleted.
+func (r *urlRepo) DailySalt(day time.Time) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	var stored VisitorSalt
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&VisitorSalt{Day: day, Salt: salt}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("day < ?", day.AddDate(0, 0, -1)).Delete(&VisitorSalt{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("day = ?", day).Take(&stored).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored.Salt, nil
+}
+
+// CountClicksByBucket groups the clicks of a URL with Postgres date_trunc in the
+// requested timezone.
+func (r *urlRepo) CountClicksByBucket(params ClickBucketParams) ([]ClickBucketCount, error) {
+	var counts []ClickBucketCount
+	err := r.db.Model(&ClickEvent{}).
+		Select("date_trunc(?, occurred_at AT TIME ZONE ?) AS bucket, count(*) AS count", string(params.Interval), params.Timezone).
+		Where("url_id = ? AND is_bot = ? AND occurred_at >= ? AND occurred_at < ?", params.URLID, params.Bots, params.From, params.To).
+		Group("bucket").
+		Order("bucket").
+		Scan(&counts).Error
+	return counts, err
+}
+
+func (r *urlRepo) clicksIn(params ClickRangeParams) *gorm.DB {
+	query := r.db.Model(&ClickEvent{}).Where("url_id = ? AND is_bot = ?", params.URLID, params.Bots)
+	if params.From != nil {
+		query = query.Where("occurred_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		query = query.Where("occurred_at < ?", *params.To)
+	}
+	return query
+}
+
+func (r *urlRepo) CountClicks(params ClickRangeParams) (int64, error) {
+	var count int64
+	err := r.clicksIn(params).Count(&count).Error
+	return count, err
+}
+
+// TopClickValues returns the most frequent values of a breakdown dimension,
+// most clicked first.
+func (r *urlRepo) TopClickValues(params ClickTopParams) ([]BreakdownItem, error) {
+	column, ok := breakdownColumns[params.Dimension]
+	if !ok {
+		return nil, errors.New("unknown breakdown dimension: " + params.Dimension)
+	}
+
+	var items []BreakdownItem
+	err := r.clicksIn(params.ClickRangeParams).
+		Select(column + " AS value, count(*) AS clicks").
+		Group(column).
+		Order("clicks DESC, value").
+		Limit(params.Limit).
+		Scan(&items).Error
+	return items, err
+}
+
+// clicksForExport selects the clicks of one URL or of every URL of an owner.
+// Clicks of soft-deleted URLs are left out.
+func (r *urlRepo) clicksForExport(params ClickExportParams) *gorm.DB {
+	query := r.db.Table("click_events").
+		Joins("JOIN urls ON urls.id = click_events.url_id AND urls.deleted_at IS NULL")
+	if params.URLID != 0 {
+		query = query.Where("click_events.url_id = ?", params.URLID)
+	}
+	if params.Owner != "" {
+		query = query.Where("urls.owner = ?", params.Owner)
+	}
+	if params.Campaign != "" {
+		query = query.Where("urls.utm_campaign = ?", params.Campaign)
+	}
+	if params.From != nil {
+		query = query.Where("click_events.occurred_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		query = query.Where("click_events.occurred_at < ?", *params.To)
+	}
+	return query
+}
+
+// EachClickEvent calls fn for every selected click in time order, reading
+// rows one at a time. It stops at the first error returned by fn.
+func (r *urlRepo) EachClickEvent(params ClickExportParams, fn func(ExportedClick) error) error {
+	rows, err := r.clicksForExport(params).
+		Select("urls.short_token, click_events.occurred_at, click_events.referrer, click_events.referrer_source, " +
+			"click_events.user_agent, click_events.ip_hash, click_events.accept_language, click_events.browser, " +
+			"click_events.os, click_events.device, click_events.country, click_events.city, click_events.is_bot").
+		Order("click_events.occurred_at, click_events.id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var click ExportedClick
+		if err := r.db.ScanRows(rows, &click); err != nil {
+			return err
+		}
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachDailyClicks calls fn with the human and bot click counts of every
+// selected URL and day, days taken in params.Timezone.
+func (r *urlRepo) EachDailyClicks(params ClickExportParams, fn func(DailyClickCount) error) error {
+	rows, err := r.clicksForExport(params).
+		Select("urls.short_token, to_char(click_events.occurred_at AT TIME ZONE ?, 'YYYY-MM-DD') AS date, "+
+			"count(*) FILTER (WHERE NOT click_events.is_bot) AS clicks, "+
+			"count(*) FILTER (WHERE click_events.is_bot) AS bot_clicks", params.Timezone).
+		Group("1, 2").
+		Order("1, 2").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var count DailyClickCount
+		if err := r.db.ScanRows(rows, &count); err != nil {
+			return err
+		}
+		if err := fn(count); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *urlRepo) NextSequence() (uint64, error) {
+	var next uint64
+	if err := r.db.Raw("SELECT nextval(?)", shortTokenSequence).Scan(&next).Error; err != nil {
+		return 0, err
+	}
+	return next, nil
+}
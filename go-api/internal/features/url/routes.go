@@ -1,19 +1,106 @@
 package url
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/nabilfikrisp/url-shortener/internal/common/geoip"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
 	"gorm.io/gorm"
 )
 
-func InitURLHandler(db *gorm.DB) URLHandler {
+// InitURLHandler wires the url feature. A nil clicks recorder records clicks
+// synchronously and a nil geo resolver skips geolocation.
+func InitURLHandler(db *gorm.DB, cfg *config.Config, clicks ClickRecorder, geo geoip.Resolver) URLHandler {
 	repo := NewURLRepo(db)
-	service := NewURLService(repo)
-	handler := NewURLHandler(service)
+	service := NewURLService(repo, NewTokenGenerator(cfg, repo), clicks, geo, cfg)
+	handler := NewURLHandler(service, cfg)
 	return handler
 }
 
-func RegisterRoutes(app *fiber.App, handler URLHandler) {
-	app.Post("/shorten", handler.Create)
+// reservedTokens are path segments used by RegisterRoutes, or by routes
+// registered next to it such as /docs, that a short token or alias must never
+// take.
+var reservedTokens = map[string]bool{
+	"shorten": true,
+	"stats":   true,
+	"urls":    true,
+	"preview": true,
+	"docs":    true,
+	"api":     true,
+	// GET /stats/export would shadow the stats of a link named "export"
+	"export": true,
+}
+
+func isReservedToken(token string) bool {
+	return reservedTokens[strings.ToLower(token)]
+}
+
+// APIPrefix is where the management API is served, leaving the root namespace
+// to redirects and the visitor facing preview.
+const APIPrefix = "/api/v1"
+
+func RegisterRoutes(app *fiber.App, handler URLHandler, cfg *config.Config) {
+	// only failed password attempts count towards the limit
+	unlockLimiter := limiter.New(limiter.Config{
+		Max:                    cfg.PasswordMaxAttempts,
+		Expiration:             cfg.PasswordAttemptWindow,
+		SkipSuccessfulRequests: true,
+		LimitReached: func(c *fiber.Ctx) error {
+			return renderPasswordForm(c, fiber.StatusTooManyRequests, passwordFormData{
+				ShortToken: c.Params("shortToken"),
+				Error:      "Too many attempts, please wait a minute and try again.",
+			})
+		},
+	})
+
+	api := app.Group(APIPrefix)
+	registerAPIRoutes(api, handler)
+	// added after the deprecation, so it has no unversioned path
+	api.Get("/urls", handler.List)
+	// the unversioned paths predate APIPrefix and are kept for existing
+	// clients. Registered before /:shortToken so POST /shorten is not an unlock.
+	registerAPIRoutes(app, handler, deprecated)
+
+	app.Get("/preview/:shortToken", handler.Preview)
+	// registered before GET, which also answers HEAD
+	app.Head("/:shortToken", handler.HeadRedirect)
 	app.Get("/:shortToken", handler.RedirectToOriginal)
-	app.Get("/stats/:shortToken", handler.FindByShortToken)
+	app.Post("/:shortToken", unlockLimiter, handler.Unlock)
+}
+
+// registerAPIRoutes registers the management API on router, each route led by
+// middleware.
+func registerAPIRoutes(router fiber.Router, handler URLHandler, middleware ...fiber.Handler) {
+	with := func(h fiber.Handler) []fiber.Handler {
+		return append(slices.Clone(middleware), h)
+	}
+
+	router.Post("/shorten", with(handler.Create)...)
+	router.Post("/shorten/batch", with(handler.CreateBatch)...)
+	router.Get("/stats/export", with(handler.ExportAccountStats)...)
+	router.Get("/stats/:shortToken", with(handler.FindByShortToken)...)
+	router.Get("/stats/:shortToken/timeseries", with(handler.ClickTimeseries)...)
+	router.Get("/stats/:shortToken/visitors", with(handler.UniqueVisitors)...)
+	router.Get("/stats/:shortToken/export", with(handler.ExportStats)...)
+	router.Get("/urls/:shortToken", with(handler.FindWithHistory)...)
+	router.Patch("/urls/:shortToken", with(handler.UpdateDestination)...)
+	router.Delete("/urls/:shortToken", with(handler.Delete)...)
+	router.Post("/urls/:shortToken/restore", with(handler.Restore)...)
+}
+
+// legacyDeprecatedAt is when the unversioned management paths were deprecated
+// in favour of APIPrefix.
+var legacyDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// deprecated marks a response from an unversioned management path with the
+// Deprecation header of RFC 9745 and links the versioned path replacing it.
+func deprecated(c *fiber.Ctx) error {
+	c.Set("Deprecation", fmt.Sprintf("@%d", legacyDeprecatedAt.Unix()))
+	c.Set(fiber.HeaderLink, fmt.Sprintf(`<%s%s>; rel="successor-version"`, APIPrefix, c.OriginalURL()))
+	return c.Next()
 }
@@ -2,73 +2,602 @@ package url
 
 import (
 	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/common/geoip"
 	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/nabilfikrisp/url-shortener/internal/common/referrer"
+	"github.com/nabilfikrisp/url-shortener/internal/common/useragent"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrURLNotFound     = apperror.New(apperror.CodeNotFound, "short URL not found")
+	ErrNotOwner        = apperror.New(apperror.CodeForbidden, "short URL belongs to another owner")
+	ErrAliasTaken      = apperror.Field(apperror.CodeConflict, "alias", "alias is already in use")
+	ErrLinkExpired     = apperror.New(apperror.CodeExpired, "short URL has expired")
+	ErrTokensExhausted = apperror.New(apperror.CodeInternal, "unable to generate a unique short token")
+	ErrPasswordNeeded  = apperror.New(apperror.CodeUnauthorized, "short URL is password protected")
+	ErrWrongPassword   = apperror.New(apperror.CodeForbidden, "incorrect password")
+)
+
+// invalidURL reports a destination that cannot be normalized.
+func invalidURL(err error) error {
+	return &apperror.Error{
+		Code:    apperror.CodeInvalidURL,
+		Message: "please provide a valid URL",
+		Fields:  []apperror.FieldError{{Field: "url", Message: "please provide a valid URL"}},
+		Err:     err,
+	}
+}
+
 type URLService interface {
-	CreateShortToken(original string) (*URLModel, error)
+	CreateShortToken(params CreateShortTokenParams) (*URLModel, error)
+	CreateShortTokens(params []CreateShortTokenParams) ([]BatchCreateResult, error)
 	FindByShortToken(shortToken string) (*URLModel, error)
-	RedirectService(shortToken string) (*URLModel, error)
+	FindWithHistory(shortToken string) (*URLModel, error)
+	UpdateDestination(params UpdateDestinationParams) (*URLModel, error)
+	Delete(shortToken string, actor string) error
+	Restore(shortToken string, actor string) (*URLModel, error)
+	PurgeDeleted(retention time.Duration) (int64, error)
+	RedirectService(shortToken string, click ClickParams) (*URLModel, error)
+	ResolveService(shortToken string) (*URLModel, error)
+	PreviewService(shortToken string) (*Preview, error)
+	UnlockService(shortToken string, password string, click ClickParams) (*URLModel, error)
+	ClickTimeseries(params TimeseriesParams) (*Timeseries, error)
+	ClickBreakdowns(params BreakdownParams) (*ClickBreakdowns, error)
+	UniqueVisitors(params VisitorsParams) (*UniqueVisitors, error)
+	ExportStats(params ExportParams) (*StatsExport, error)
+	ListURLs(params ListParams) (*URLPage, error)
 }
 type urlService struct {
-	repo URLRepo
+	repo       URLRepo
+	clicks     ClickRecorder
+	geo        geoip.Resolver
+	generator  helpers.TokenGenerator
+	normalize  helpers.NormalizeURLOptions
+	ipHashSalt string
+	salt       *dailySalt
 }
 
-func NewURLService(repo URLRepo) URLService {
+// NewURLService builds the service. A nil clicks recorder writes clicks
+// synchronously through repo, and a nil geo resolver skips geolocation.
+func NewURLService(repo URLRepo, generator helpers.TokenGenerator, clicks ClickRecorder, geo geoip.Resolver, cfg *config.Config) URLService {
+	if clicks == nil {
+		clicks = NewSyncClickRecorder(repo)
+	}
+	if geo == nil {
+		geo = geoip.Disabled()
+	}
+
 	return &urlService{
-		repo: repo,
+		repo:      repo,
+		clicks:    clicks,
+		geo:       geo,
+		generator: generator,
+		normalize: helpers.NormalizeURLOptions{
+			StripParams:       cfg.StripQueryParams,
+			TrimTrailingSlash: cfg.TrimTrailingSlash,
+		},
+		ipHashSalt: cfg.IPHashSalt,
+		salt:       new(dailySalt),
+	}
+}
+
+type CreateShortTokenParams struct {
+	Original string
+	// Alias is an optional caller-chosen token used instead of a generated one.
+	Alias     string
+	ExpiresAt *time.Time
+	MaxClicks *int
+	// Owner is the caller creating the URL, empty for anonymous requests.
+	Owner string
+	// Password, when set, must be entered before the link redirects.
+	Password string
+	// RedirectType is the redirect status code, zero for the configured
+	// default.
+	RedirectType int
+	// UTM fields are set on Original's query, replacing parameters of the
+	// same name.
+	UTM UTM
+	// Tags label the link; they are normalized by normalizeTags.
+	Tags []string
+
+	rawOriginal  string
+	passwordHash string
+}
+
+// matches reports whether an existing URL can be handed back for params
+// instead of creating a new one.
+func (p CreateShortTokenParams) matches(url *URLModel) bool {
+	// deleted, dead and password protected links are never shared
+	if url.DeletedAt.Valid || url.IsExpired(time.Now()) || url.IsProtected() || p.Password != "" {
+		return false
+	}
+	if url.Original != p.Original || url.Owner != p.Owner || url.UTM != p.UTM {
+		return false
+	}
+	if url.RedirectType != p.RedirectType || !slices.Equal(url.Tags, p.Tags) {
+		return false
+	}
+	if !equalPtr(url.ExpiresAt, p.ExpiresAt, func(a, b time.Time) bool { return a.Equal(b) }) {
+		return false
+	}
+	return equalPtr(url.MaxClicks, p.MaxClicks, func(a, b int) bool { return a == b })
+}
+
+func equalPtr[T any](a, b *T, eq func(T, T) bool) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
 	}
+	return eq(*a, *b)
 }
 
-func (s *urlService) CreateShortToken(original string) (*URLModel, error) {
-	shortToken := helpers.GenerateShortToken(original)
+// maxTokenAttempts bounds how many tokens are tried before giving up on
+// finding a free one. It leaves the hash generator a few random attempts
+// after its HashAttempts deterministic ones.
+const maxTokenAttempts = helpers.HashAttempts + 5
+
+// prepared returns params with Original replaced by its normalized form, the
+// tags normalized and the password hashed.
+func (s *urlService) prepared(params CreateShortTokenParams) (CreateShortTokenParams, error) {
+	normalized, err := helpers.NormalizeURL(params.Original, s.normalize)
+	if err != nil {
+		return params, invalidURL(err)
+	}
+	params.rawOriginal = params.Original
+	params.Original = normalized
+	params.Tags = normalizeTags(params.Tags)
+
+	// merged after normalizing so STRIP_QUERY_PARAMS only drops parameters
+	// that came with the submitted URL
+	params.UTM = params.UTM.trimmed()
+	if params.UTM != (UTM{}) {
+		if params.Original, err = helpers.SetQueryParams(params.Original, params.UTM.queryParams()); err != nil {
+			return params, err
+		}
+	}
+
+	if params.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return params, err
+		}
+		params.passwordHash = string(hash)
+	}
+
+	return params, nil
+}
+
+func (s *urlService) CreateShortToken(params CreateShortTokenParams) (*URLModel, error) {
+	params, err := s.prepared(params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Alias != "" {
+		return s.createWithAlias(params)
+	}
+
+	for attempt := range maxTokenAttempts {
+		shortToken, err := s.generator.Generate(params.Original, attempt)
+		if err != nil {
+			return nil, err
+		}
+		if isReservedToken(shortToken) {
+			continue
+		}
+
+		existingURL, err := s.repo.FindByShortToken(shortToken)
+		if err != nil {
+			return nil, err
+		}
+		if existingURL != nil {
+			if params.matches(existingURL) {
+				return existingURL, nil
+			}
+			// token taken by a different link, try the next one
+			continue
+		}
+
+		url, err := s.create(params, shortToken)
+		if errors.Is(err, ErrShortTokenTaken) {
+			// held by a soft-deleted URL or a concurrent insert
+			continue
+		}
+		return url, err
+	}
+
+	return nil, ErrTokensExhausted
+}
 
-	existingURL, err := s.repo.FindByShortToken(shortToken)
+func (s *urlService) createWithAlias(params CreateShortTokenParams) (*URLModel, error) {
+	existingURL, err := s.repo.FindByShortToken(params.Alias)
 	if err != nil {
 		return nil, err
 	}
 	if existingURL != nil {
-		return existingURL, nil
+		if params.matches(existingURL) {
+			return existingURL, nil
+		}
+		return nil, ErrAliasTaken
 	}
 
-	url := &URLModel{
-		Original:   original,
-		ShortToken: shortToken,
+	url, err := s.create(params, params.Alias)
+	if errors.Is(err, ErrShortTokenTaken) {
+		return nil, ErrAliasTaken
 	}
+	return url, err
+}
+
+func (s *urlService) create(params CreateShortTokenParams, shortToken string) (*URLModel, error) {
+	url := params.newURL(shortToken)
 	if err := s.repo.Create(url); err != nil {
 		return nil, err
 	}
 	return url, nil
 }
 
+func (p CreateShortTokenParams) newURL(shortToken string) *URLModel {
+	return &URLModel{
+		Original:     p.Original,
+		RawOriginal:  p.rawOriginal,
+		ShortToken:   shortToken,
+		ExpiresAt:    p.ExpiresAt,
+		MaxClicks:    p.MaxClicks,
+		RedirectType: p.RedirectType,
+		Owner:        p.Owner,
+		PasswordHash: p.passwordHash,
+		UTM:          p.UTM,
+		Tags:         p.Tags,
+		Domain:       helpers.URLDomain(p.Original),
+	}
+}
+
+// BatchCreateResult is the outcome for one item of CreateShortTokens. Exactly
+// one of URL and Err is set.
+type BatchCreateResult struct {
+	URL *URLModel
+	Err error
+}
+
+// CreateShortTokens resolves tokens for all params with one lookup per
+// attempt and inserts the new URLs in a single transaction. Results are in
+// the same order as params.
+func (s *urlService) CreateShortTokens(params []CreateShortTokenParams) ([]BatchCreateResult, error) {
+	results := make([]BatchCreateResult, len(params))
+	params = slices.Clone(params)
+
+	var pending []int
+	for i := range params {
+		prepared, err := s.prepared(params[i])
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		params[i] = prepared
+		pending = append(pending, i)
+	}
+
+	// claimed maps a token to the URL this batch will create under it
+	claimed := map[string]*URLModel{}
+	var newURLs []*URLModel
+
+	for attempt := 0; attempt < maxTokenAttempts && len(pending) > 0; attempt++ {
+		candidates := make(map[int]string, len(pending))
+		tokens := make([]string, 0, len(pending))
+		for _, i := range pending {
+			token := params[i].Alias
+			if token == "" {
+				generated, err := s.generator.Generate(params[i].Original, attempt)
+				if err != nil {
+					return nil, err
+				}
+				if isReservedToken(generated) {
+					continue
+				}
+				token = generated
+			}
+			candidates[i] = token
+			tokens = append(tokens, token)
+		}
+
+		existing, err := s.repo.FindByShortTokens(tokens)
+		if err != nil {
+			return nil, err
+		}
+		taken := make(map[string]*URLModel, len(existing))
+		for _, url := range existing {
+			taken[url.ShortToken] = url
+		}
+
+		var retry []int
+		for _, i := range pending {
+			token, ok := candidates[i]
+			if !ok {
+				retry = append(retry, i)
+				continue
+			}
+
+			owner := taken[token]
+			if owner == nil {
+				owner = claimed[token]
+			}
+
+			switch {
+			case owner == nil:
+				url := params[i].newURL(token)
+				claimed[token] = url
+				newURLs = append(newURLs, url)
+				results[i].URL = url
+			case params[i].matches(owner):
+				results[i].URL = owner
+			case params[i].Alias != "":
+				results[i].Err = ErrAliasTaken
+			default:
+				retry = append(retry, i)
+			}
+		}
+		pending = retry
+	}
+
+	for _, i := range pending {
+		results[i].Err = ErrTokensExhausted
+	}
+
+	if len(newURLs) > 0 {
+		if err := s.repo.CreateBatch(newURLs); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
 func (s *urlService) FindByShortToken(shortToken string) (*URLModel, error) {
 	url, err := s.repo.FindByShortToken(shortToken)
 	if err != nil {
 		return nil, err
 	}
 	if url == nil {
-		return nil, errors.New("short URL not found")
+		return nil, ErrURLNotFound
+	}
+	return url, nil
+}
+
+func (s *urlService) FindWithHistory(shortToken string) (*URLModel, error) {
+	url, err := s.repo.FindByShortTokenWithHistory(shortToken)
+	if err != nil {
+		return nil, err
+	}
+	if url == nil {
+		return nil, ErrURLNotFound
+	}
+	return url, nil
+}
+
+type UpdateDestinationParams struct {
+	ShortToken string
+	Original   string
+	// Actor is the caller making the change; only the URL's owner may do so.
+	Actor string
+}
+
+func (s *urlService) UpdateDestination(params UpdateDestinationParams) (*URLModel, error) {
+	normalized, err := helpers.NormalizeURL(params.Original, s.normalize)
+	if err != nil {
+		return nil, invalidURL(err)
+	}
+
+	url, err := s.repo.FindByShortToken(params.ShortToken)
+	if err != nil {
+		return nil, err
+	}
+	if url == nil {
+		return nil, ErrURLNotFound
+	}
+	if !url.OwnedBy(params.Actor) {
+		return nil, ErrNotOwner
+	}
+	// the link keeps its UTM fields, so they are merged into the new
+	// destination like on create
+	if url.UTM != (UTM{}) {
+		if normalized, err = helpers.SetQueryParams(normalized, url.UTM.queryParams()); err != nil {
+			return nil, err
+		}
+	}
+	if url.Original == normalized {
+		return url, nil
+	}
+
+	history := &URLDestinationHistory{
+		URLID:     url.ID,
+		Original:  url.Original,
+		ChangedBy: params.Actor,
+		ChangedAt: time.Now(),
+	}
+	url.Original = normalized
+	url.RawOriginal = params.Original
+	url.Domain = helpers.URLDomain(normalized)
+
+	if err := s.repo.UpdateDestination(url, history); err != nil {
+		return nil, err
+	}
+	return url, nil
+}
+
+// Delete soft-deletes the URL. Its token stays reserved until the URL is
+// purged, so it can still be restored and is never handed to another link.
+func (s *urlService) Delete(shortToken string, actor string) error {
+	url, err := s.repo.FindByShortToken(shortToken)
+	if err != nil {
+		return err
+	}
+	if url == nil {
+		return ErrURLNotFound
+	}
+	if !url.OwnedBy(actor) {
+		return ErrNotOwner
+	}
+
+	return s.repo.SoftDelete(url)
+}
+
+func (s *urlService) Restore(shortToken string, actor string) (*URLModel, error) {
+	url, err := s.repo.FindDeletedByShortToken(shortToken)
+	if err != nil {
+		return nil, err
+	}
+	if url == nil {
+		return nil, ErrURLNotFound
+	}
+	if !url.OwnedBy(actor) {
+		return nil, ErrNotOwner
+	}
+
+	if err := s.repo.Restore(url); err != nil {
+		return nil, err
 	}
 	return url, nil
 }
 
-func (s *urlService) RedirectService(shortToken string) (*URLModel, error) {
+// PurgeDeleted hard-deletes URLs that were soft-deleted more than retention
+// ago and returns how many were removed.
+func (s *urlService) PurgeDeleted(retention time.Duration) (int64, error) {
+	return s.repo.PurgeDeleted(time.Now().Add(-retention))
+}
+
+// redirectable returns the URL behind shortToken if it may still redirect.
+// It reads the database on every call, uncached, so retargets and deletes take
+// effect on the next redirect.
+func (s *urlService) redirectable(shortToken string) (*URLModel, error) {
 	url, err := s.repo.FindByShortToken(shortToken)
 	if err != nil {
 		return nil, err
 	}
 	if url == nil {
-		return nil, errors.New("short URL not found")
+		return nil, ErrURLNotFound
+	}
+	if url.IsExpired(time.Now()) {
+		return nil, ErrLinkExpired
+	}
+	return url, nil
+}
+
+// ClickParams describes the request behind a redirect.
+type ClickParams struct {
+	Referrer       string
+	UserAgent      string
+	IP             string
+	AcceptLanguage string
+	// Prefetch is set for requests that are not a visit, such as browser
+	// prefetches.
+	Prefetch bool
+}
+
+func (s *urlService) countClick(url *URLModel, click ClickParams) error {
+	agent := useragent.Parse(click.UserAgent)
+	location := s.geo.Lookup(click.IP)
+	event := &ClickEvent{
+		URLID:          url.ID,
+		OccurredAt:     time.Now(),
+		Referrer:       click.Referrer,
+		ReferrerSource: referrer.Source(click.Referrer),
+		UserAgent:      click.UserAgent,
+		IPHash:         helpers.HashIP(click.IP, s.ipHashSalt),
+		AcceptLanguage: click.AcceptLanguage,
+		Browser:        agent.Browser,
+		OS:             agent.OS,
+		Device:         agent.Device,
+		Country:        location.Country,
+		City:           location.City,
+		IsBot:          agent.IsBot() || click.Prefetch,
+	}
+
+	if !event.IsBot {
+		// a missing salt only costs the unique visitor count, not the redirect
+		if salt, err := s.visitorSalt(visitorDay(event.OccurredAt)); err == nil {
+			event.Visitor = helpers.VisitorFingerprint(click.IP, click.UserAgent, salt)
+		} else {
+			log.Printf("visitor salt: %v", err)
+		}
+	}
+
+	// click limits are checked against click_count, so it must not lag behind
+	if url.MaxClicks != nil && !event.IsBot {
+		return NewSyncClickRecorder(s.repo).Record(event)
+	}
+	return s.clicks.Record(event)
+}
+
+// dailySalt caches the visitor salt of the current day.
+type dailySalt struct {
+	mu   sync.Mutex
+	day  time.Time
+	salt []byte
+}
+
+func (s *urlService) visitorSalt(day time.Time) ([]byte, error) {
+	s.salt.mu.Lock()
+	defer s.salt.mu.Unlock()
+
+	if s.salt.salt != nil && s.salt.day.Equal(day) {
+		return s.salt.salt, nil
 	}
 
-	affectedRows, err := s.repo.IncrementClickCount(shortToken)
+	salt, err := s.repo.DailySalt(day)
 	if err != nil {
 		return nil, err
 	}
-	if affectedRows == 0 {
-		return nil, errors.New("unable to update click statistics")
+	s.salt.day, s.salt.salt = day, salt
+	return salt, nil
+}
+
+func (s *urlService) RedirectService(shortToken string, click ClickParams) (*URLModel, error) {
+	url, err := s.ResolveService(shortToken)
+	if err != nil {
+		return nil, err
 	}
 
+	if err := s.countClick(url, click); err != nil {
+		return nil, err
+	}
+	return url, nil
+}
+
+// ResolveService runs the checks of RedirectService without counting a
+// click.
+func (s *urlService) ResolveService(shortToken string) (*URLModel, error) {
+	url, err := s.redirectable(shortToken)
+	if err != nil {
+		return nil, err
+	}
+	if url.IsProtected() {
+		return nil, ErrPasswordNeeded
+	}
+	return url, nil
+}
+
+// UnlockService checks the password of a protected URL and counts the click
+// when it matches.
+func (s *urlService) UnlockService(shortToken string, password string, click ClickParams) (*URLModel, error) {
+	url, err := s.redirectable(shortToken)
+	if err != nil {
+		return nil, err
+	}
+	if url.IsProtected() {
+		if err := bcrypt.CompareHashAndPassword([]byte(url.PasswordHash), []byte(password)); err != nil {
+			return nil, ErrWrongPassword
+		}
+	}
+
+	if err := s.countClick(url, click); err != nil {
+		return nil, err
+	}
 	return url, nil
 }
@@ -0,0 +1,402 @@
+package url
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/common/hll"
+)
+
+// StatsInterval is the bucket size of a click time series.
+type StatsInterval string
+
+const (
+	StatsIntervalHour StatsInterval = "hour"
+	StatsIntervalDay  StatsInterval = "day"
+	StatsIntervalWeek StatsInterval = "week"
+)
+
+// maxTimeseriesBuckets caps how many buckets one request may produce.
+const maxTimeseriesBuckets = 1000
+
+var ErrInvalidStatsQuery = apperror.New(apperror.CodeInvalidRequest, "invalid stats query")
+
+func (i StatsInterval) Valid() bool {
+	switch i {
+	case StatsIntervalHour, StatsIntervalDay, StatsIntervalWeek:
+		return true
+	}
+	return false
+}
+
+// truncate rounds a wall clock time down to the start of its bucket. Weeks
+// start on Monday, like Postgres date_trunc.
+func (i StatsInterval) truncate(wall time.Time) time.Time {
+	switch i {
+	case StatsIntervalHour:
+		return wall.Truncate(time.Hour)
+	case StatsIntervalWeek:
+		day := time.Date(wall.Year(), wall.Month(), wall.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Date(wall.Year(), wall.Month(), wall.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func (i StatsInterval) next(wall time.Time) time.Time {
+	switch i {
+	case StatsIntervalHour:
+		return wall.Add(time.Hour)
+	case StatsIntervalWeek:
+		return wall.AddDate(0, 0, 7)
+	default:
+		return wall.AddDate(0, 0, 1)
+	}
+}
+
+// defaultFrom is how far back a series reaches when no start is given.
+func (i StatsInterval) defaultFrom(to time.Time) time.Time {
+	switch i {
+	case StatsIntervalHour:
+		return to.Add(-24 * time.Hour)
+	case StatsIntervalWeek:
+		return to.AddDate(0, 0, -7*12)
+	default:
+		return to.AddDate(0, 0, -30)
+	}
+}
+
+// wallClock re-expresses the local date and time of t in loc as UTC. Buckets
+// are computed on wall clock times so days and weeks follow the requested
+// timezone across DST changes.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	l := t.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}
+
+func fromWallClock(wall time.Time, loc *time.Location) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+}
+
+type TimeseriesParams struct {
+	ShortToken string
+	Interval   StatsInterval
+	// From and To bound the series, From inclusive and To exclusive. When
+	// nil, To is now and From depends on Interval.
+	From     *time.Time
+	To       *time.Time
+	Location *time.Location
+	// Bots counts bot clicks instead of human ones.
+	Bots bool
+}
+
+type Timeseries struct {
+	ShortToken string             `json:"short_token"`
+	Interval   StatsInterval      `json:"interval"`
+	Timezone   string             `json:"timezone"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Total      int64              `json:"total"`
+	Buckets    []TimeseriesBucket `json:"buckets"`
+}
+
+type TimeseriesBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+}
+
+// ClickBucketParams selects the clicks of one URL counted per bucket. Buckets
+// are truncated in Timezone.
+type ClickBucketParams struct {
+	URLID    uint
+	Interval StatsInterval
+	Timezone string
+	From     time.Time
+	To       time.Time
+	Bots     bool
+}
+
+// ClickBucketCount is the number of clicks in the bucket starting at Bucket,
+// a wall clock time in the requested timezone expressed as UTC.
+type ClickBucketCount struct {
+	Bucket time.Time
+	Count  int64
+}
+
+// ClickTimeseries counts the clicks of a URL per interval, filling buckets
+// without clicks with zero.
+func (s *urlService) ClickTimeseries(params TimeseriesParams) (*Timeseries, error) {
+	if !params.Interval.Valid() {
+		return nil, fmt.Errorf("%w: interval must be hour, day or week", ErrInvalidStatsQuery)
+	}
+	loc := params.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	to := time.Now()
+	if params.To != nil {
+		to = *params.To
+	}
+	from := params.Interval.defaultFrom(to)
+	if params.From != nil {
+		from = *params.From
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidStatsQuery)
+	}
+
+	start := params.Interval.truncate(wallClock(from, loc))
+	end := wallClock(to, loc)
+
+	var walls []time.Time
+	for b := start; b.Before(end); b = params.Interval.next(b) {
+		if len(walls) == maxTimeseriesBuckets {
+			return nil, fmt.Errorf("%w: at most %d buckets are allowed, use a larger interval or a shorter range", ErrInvalidStatsQuery, maxTimeseriesBuckets)
+		}
+		walls = append(walls, b)
+	}
+
+	url, err := s.FindByShortToken(params.ShortToken)
+	if err != nil {
+		return nil, err
+	}
+
+	counts, err := s.repo.CountClicksByBucket(ClickBucketParams{
+		URLID:    url.ID,
+		Interval: params.Interval,
+		Timezone: loc.String(),
+		From:     from,
+		To:       to,
+		Bots:     params.Bots,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byBucket := make(map[time.Time]int64, len(counts))
+	for _, count := range counts {
+		byBucket[count.Bucket.UTC()] += count.Count
+	}
+
+	series := &Timeseries{
+		ShortToken: url.ShortToken,
+		Interval:   params.Interval,
+		Timezone:   loc.String(),
+		From:       from.In(loc),
+		To:         to.In(loc),
+		Buckets:    make([]TimeseriesBucket, 0, len(walls)),
+	}
+	for _, wall := range walls {
+		clicks := byBucket[wall]
+		series.Total += clicks
+		series.Buckets = append(series.Buckets, TimeseriesBucket{
+			Start:  fromWallClock(wall, loc),
+			Clicks: clicks,
+		})
+	}
+	return series, nil
+}
+
+const (
+	defaultBreakdownTop = 5
+	maxBreakdownTop     = 50
+)
+
+// breakdownColumns maps each dimension clicks can be broken down by to its
+// click_events column.
+var breakdownColumns = map[string]string{
+	"referrer": "referrer_source",
+	"browser":  "browser",
+	"os":       "os",
+	"device":   "device",
+	"country":  "country",
+	"city":     "city",
+}
+
+// BreakdownDimensions lists the breakdown dimensions in response order.
+var BreakdownDimensions = []string{"referrer", "browser", "os", "device", "country", "city"}
+
+func IsBreakdownDimension(dimension string) bool {
+	_, ok := breakdownColumns[dimension]
+	return ok
+}
+
+// ClickRangeParams selects the clicks of one URL, optionally bounded by From
+// (inclusive) and To (exclusive).
+type ClickRangeParams struct {
+	URLID uint
+	From  *time.Time
+	To    *time.Time
+	// Bots selects bot clicks instead of human ones.
+	Bots bool
+}
+
+type ClickTopParams struct {
+	ClickRangeParams
+	Dimension string
+	Limit     int
+}
+
+type BreakdownParams struct {
+	URLID      uint
+	Dimensions []string
+	// Top is how many values to return per dimension. Zero means 5.
+	Top  int
+	From *time.Time
+	To   *time.Time
+	Bots bool
+}
+
+type BreakdownItem struct {
+	Value  string  `json:"value"`
+	Clicks int64   `json:"clicks"`
+	Share  float64 `json:"share"`
+}
+
+// ClickBreakdowns holds the most common values of each dimension. Share is the
+// fraction of Total clicks carrying the value.
+type ClickBreakdowns struct {
+	Total int64                      `json:"total"`
+	Top   map[string][]BreakdownItem `json:"top"`
+}
+
+// URLStats is a URL along with the breakdown of its clicks.
+type URLStats struct {
+	*URLModel
+	Breakdowns *ClickBreakdowns `json:"breakdowns"`
+}
+
+func (s *urlService) ClickBreakdowns(params BreakdownParams) (*ClickBreakdowns, error) {
+	top := params.Top
+	if top == 0 {
+		top = defaultBreakdownTop
+	}
+	if top < 1 || top > maxBreakdownTop {
+		return nil, fmt.Errorf("%w: top must be between 1 and %d", ErrInvalidStatsQuery, maxBreakdownTop)
+	}
+
+	dimensions := params.Dimensions
+	if len(dimensions) == 0 {
+		dimensions = BreakdownDimensions
+	}
+	for _, dimension := range dimensions {
+		if !IsBreakdownDimension(dimension) {
+			return nil, fmt.Errorf("%w: unknown breakdown %q", ErrInvalidStatsQuery, dimension)
+		}
+	}
+
+	scope := ClickRangeParams{URLID: params.URLID, From: params.From, To: params.To, Bots: params.Bots}
+	total, err := s.repo.CountClicks(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	breakdowns := &ClickBreakdowns{Total: total, Top: make(map[string][]BreakdownItem, len(dimensions))}
+	for _, dimension := range dimensions {
+		items, err := s.repo.TopClickValues(ClickTopParams{ClickRangeParams: scope, Dimension: dimension, Limit: top})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range items {
+			if items[i].Value == "" {
+				items[i].Value = "Unknown"
+			}
+			if total > 0 {
+				items[i].Share = math.Round(float64(items[i].Clicks)/float64(total)*10000) / 10000
+			}
+		}
+		breakdowns.Top[dimension] = items
+	}
+	return breakdowns, nil
+}
+
+// maxVisitorDays caps the range of one unique visitors request.
+const maxVisitorDays = 366
+
+type VisitorsParams struct {
+	ShortToken string
+	// From and To bound the UTC days counted, From inclusive and To
+	// exclusive. When nil the last 30 days up to and including today are
+	// counted.
+	From *time.Time
+	To   *time.Time
+}
+
+// UniqueVisitors holds approximate distinct visitor counts. Visitors are
+// fingerprinted with a salt that changes every day, so Total counts a visitor
+// returning on several days once per day.
+type UniqueVisitors struct {
+	ShortToken string          `json:"short_token"`
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	Total      uint64          `json:"total"`
+	Days       []DailyVisitors `json:"days"`
+}
+
+type DailyVisitors struct {
+	Date     string `json:"date"`
+	Visitors uint64 `json:"visitors"`
+}
+
+// UniqueVisitors merges the daily visitor sketches of a URL over a range of
+// days.
+func (s *urlService) UniqueVisitors(params VisitorsParams) (*UniqueVisitors, error) {
+	to := visitorDay(time.Now()).AddDate(0, 0, 1)
+	if params.To != nil {
+		to = visitorDay(*params.To)
+		if !to.Equal(params.To.UTC()) {
+			to = to.AddDate(0, 0, 1)
+		}
+	}
+	from := to.AddDate(0, 0, -30)
+	if params.From != nil {
+		from = visitorDay(*params.From)
+	}
+
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidStatsQuery)
+	}
+	if from.AddDate(0, 0, maxVisitorDays).Before(to) {
+		return nil, fmt.Errorf("%w: at most %d days are allowed", ErrInvalidStatsQuery, maxVisitorDays)
+	}
+
+	url, err := s.FindByShortToken(params.ShortToken)
+	if err != nil {
+		return nil, err
+	}
+
+	sketches, err := s.repo.FindVisitorSketches(url.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[time.Time]*hll.Sketch, len(sketches))
+	total := hll.New()
+	for _, stored := range sketches {
+		sketch, err := hll.FromBytes(stored.Sketch)
+		if err != nil {
+			return nil, err
+		}
+		byDay[visitorDay(stored.Day)] = sketch
+		total.Merge(sketch)
+	}
+
+	visitors := &UniqueVisitors{
+		ShortToken: url.ShortToken,
+		From:       from.Format(time.DateOnly),
+		To:         to.Format(time.DateOnly),
+		Total:      total.Estimate(),
+	}
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		var count uint64
+		if sketch, ok := byDay[day]; ok {
+			count = sketch.Estimate()
+		}
+		visitors.Days = append(visitors.Days, DailyVisitors{Date: day.Format(time.DateOnly), Visitors: count})
+	}
+	return visitors, nil
+}
@@ -0,0 +1,199 @@
+package url
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/common/middleware"
+	"github.com/nabilfikrisp/url-shortener/internal/common/response"
+)
+
+// parseStatsQuery reads the from, to and tz query parameters shared by the
+// stats endpoints. Dates without a time are read in tz, and a date-only "to"
+// includes that whole day.
+func parseStatsQuery(c *fiber.Ctx) (from *time.Time, to *time.Time, loc *time.Location, err error) {
+	loc = time.UTC
+	if tz := c.Query("tz"); tz != "" {
+		// "Local" is the server's zone, which Postgres knows no name for
+		if loc, err = time.LoadLocation(tz); err != nil || tz == "Local" {
+			return nil, nil, nil, apperror.Field(apperror.CodeInvalidRequest, "tz", "tz must be an IANA timezone such as Asia/Jakarta")
+		}
+	}
+
+	if from, err = parseStatsTime(c.Query("from"), loc, false); err != nil {
+		return nil, nil, nil, invalidStatsTime("from")
+	}
+	if to, err = parseStatsTime(c.Query("to"), loc, true); err != nil {
+		return nil, nil, nil, invalidStatsTime("to")
+	}
+	return from, to, loc, nil
+}
+
+func invalidStatsTime(field string) error {
+	return apperror.Field(apperror.CodeInvalidRequest, field, field+" must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+}
+
+func parseStatsTime(value string, loc *time.Location, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// breakdownParams reads the top, breakdown, from, to and tz query parameters
+// of GET /stats/:shortToken.
+func breakdownParams(c *fiber.Ctx, url *URLModel) (BreakdownParams, error) {
+	params := BreakdownParams{URLID: url.ID}
+
+	from, to, _, err := parseStatsQuery(c)
+	if err != nil {
+		return params, err
+	}
+	params.From, params.To = from, to
+	params.Bots = c.QueryBool("bots")
+
+	if top := c.Query("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 1 || n > maxBreakdownTop {
+			return params, apperror.Field(apperror.CodeInvalidRequest, "top", fmt.Sprintf("top must be a number between 1 and %d", maxBreakdownTop))
+		}
+		params.Top = n
+	}
+
+	if breakdown := c.Query("breakdown"); breakdown != "" {
+		for _, dimension := range strings.Split(breakdown, ",") {
+			dimension = strings.TrimSpace(dimension)
+			if !IsBreakdownDimension(dimension) {
+				return params, apperror.Field(apperror.CodeInvalidRequest, "breakdown",
+					"breakdown must be a comma separated list of "+strings.Join(BreakdownDimensions, ", "))
+			}
+			params.Dimensions = append(params.Dimensions, dimension)
+		}
+	}
+	return params, nil
+}
+
+func (h *urlHandler) ClickTimeseries(c *fiber.Ctx) error {
+	from, to, loc, err := parseStatsQuery(c)
+	if err != nil {
+		return err
+	}
+
+	interval := StatsInterval(c.Query("interval", string(StatsIntervalDay)))
+	if !interval.Valid() {
+		return apperror.Field(apperror.CodeInvalidRequest, "interval", "interval must be hour, day or week")
+	}
+
+	series, err := h.service.ClickTimeseries(TimeseriesParams{
+		ShortToken: c.Params("shortToken"),
+		Interval:   interval,
+		From:       from,
+		To:         to,
+		Location:   loc,
+		Bots:       c.QueryBool("bots"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Click timeseries retrieved successfully",
+		Data:    series,
+	}))
+}
+
+func (h *urlHandler) UniqueVisitors(c *fiber.Ctx) error {
+	// visitor sketches are kept per UTC day
+	from, err := parseStatsTime(c.Query("from"), time.UTC, false)
+	if err != nil {
+		return invalidStatsTime("from")
+	}
+	to, err := parseStatsTime(c.Query("to"), time.UTC, true)
+	if err != nil {
+		return invalidStatsTime("to")
+	}
+
+	visitors, err := h.service.UniqueVisitors(VisitorsParams{
+		ShortToken: c.Params("shortToken"),
+		From:       from,
+		To:         to,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response.SuccessPayload(response.SuccessPayloadParams{
+		Message: "Unique visitors retrieved successfully",
+		Data:    visitors,
+	}))
+}
+
+// ExportStats streams the clicks of one short URL. Click events carry
+// referrers, user agents and IP hashes, so only the owner may export them.
+func (h *urlHandler) ExportStats(c *fiber.Ctx) error {
+	owner := middleware.Owner(c)
+	if owner == "" && ExportData(c.Query("data", string(ExportEvents))) == ExportEvents {
+		return apperror.New(apperror.CodeUnauthorized, "API key required, click events are only exported to the owner of the short URL")
+	}
+	return h.exportStats(c, c.Params("shortToken"), owner)
+}
+
+// ExportAccountStats streams the clicks of every short URL owned by the
+// caller.
+func (h *urlHandler) ExportAccountStats(c *fiber.Ctx) error {
+	owner := middleware.Owner(c)
+	if owner == "" {
+		return apperror.New(apperror.CodeUnauthorized, "API key required, exporting an account requires the X-API-Key of its owner")
+	}
+	return h.exportStats(c, "", owner)
+}
+
+func (h *urlHandler) exportStats(c *fiber.Ctx, shortToken string, owner string) error {
+	from, to, loc, err := parseStatsQuery(c)
+	if err != nil {
+		return err
+	}
+
+	export, err := h.service.ExportStats(ExportParams{
+		ShortToken: shortToken,
+		Owner:      owner,
+		Format:     ExportFormat(c.Query("format", string(ExportCSV))),
+		Data:       ExportData(c.Query("data", string(ExportEvents))),
+		From:       from,
+		To:         to,
+		Location:   loc,
+		Campaign:   c.Query("campaign"),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.Attachment(export.Filename)
+	c.Set(fiber.HeaderContentType, export.Format.ContentType())
+	// rows are read while the response is written, after this handler returns,
+	// so a failure can only cut the body short
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := export.Stream(w); err != nil {
+			log.Printf("export %s: %v", export.Filename, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
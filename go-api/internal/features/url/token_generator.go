@@ -0,0 +1,38 @@
+package url
+
+import (
+	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+)
+
+// Default token lengths per strategy when config.TokenLength is unset.
+const (
+	defaultHashTokenLength    = 16
+	defaultRandomTokenLength  = 7
+	defaultHashidsTokenLength = 6
+)
+
+// NewTokenGenerator builds the token generator selected by cfg.TokenStrategy.
+func NewTokenGenerator(cfg *config.Config, repo URLRepo) helpers.TokenGenerator {
+	length := cfg.TokenLength
+
+	switch cfg.TokenStrategy {
+	case config.TokenStrategyRandom:
+		if length == 0 {
+			length = defaultRandomTokenLength
+		}
+		return helpers.NewRandomTokenGenerator(length)
+	case config.TokenStrategySequence:
+		return helpers.NewSequenceTokenGenerator(repo.NextSequence, length)
+	case config.TokenStrategyHashids:
+		if length == 0 {
+			length = defaultHashidsTokenLength
+		}
+		return helpers.NewHashidsTokenGenerator(repo.NextSequence, cfg.TokenSalt, length)
+	default:
+		if length == 0 {
+			length = defaultHashTokenLength
+		}
+		return helpers.NewHashTokenGenerator(length)
+	}
+}
@@ -20,16 +20,17 @@ func SetupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	db, _ := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
 	})
 
 	// reset schema before each test
-	db.Migrator().DropTable(&url.URLModel{})
-	db.AutoMigrate(&url.URLModel{})
+	db.Migrator().DropTable(url.Models()...)
+	url.Migrate(db)
 
 	// cleanup after test
 	t.Cleanup(func() {
-		db.Migrator().DropTable(&url.URLModel{})
+		db.Migrator().DropTable(url.Models()...)
 	})
 
 	return db
@@ -0,0 +1,132 @@
+package integration
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+	"github.com/nabilfikrisp/url-shortener/internal/docs"
+	"github.com/nabilfikrisp/url-shortener/internal/features/url"
+	"github.com/stretchr/testify/assert"
+)
+
+// openAPISpec is the part of the OpenAPI document the tests inspect.
+type openAPISpec struct {
+	OpenAPI    string                                `json:"openapi"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	} `json:"components"`
+}
+
+// setupDocsApp registers the routes the way main does, without a database.
+func setupDocsApp() *fiber.App {
+	cfg := &config.Config{}
+	app := newFiberApp()
+	docs.RegisterRoutes(app)
+	url.RegisterRoutes(app, url.NewURLHandler(new(MockURLService), cfg), cfg)
+	return app
+}
+
+var routeParam = regexp.MustCompile(`:(\w+)`)
+
+func TestDocs(t *testing.T) {
+	var spec openAPISpec
+	if err := json.Unmarshal(docs.OpenAPI, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	t.Run("Spec is OpenAPI 3.1 with the response envelopes", func(t *testing.T) {
+		assert.True(t, strings.HasPrefix(spec.OpenAPI, "3.1."))
+		assert.Contains(t, spec.Components.Schemas, "Success")
+		assert.Contains(t, spec.Components.Schemas, "Error")
+	})
+
+	t.Run("Every registered route is documented", func(t *testing.T) {
+		for _, route := range setupDocsApp().GetRoutes(true) {
+			path := routeParam.ReplaceAllString(route.Path, "{$1}")
+			method := strings.ToLower(route.Method)
+
+			operations, ok := spec.Paths[path]
+			if !assert.Truef(t, ok, "%s %s is missing from openapi.json", route.Method, route.Path) {
+				continue
+			}
+			// fiber answers HEAD for every GET route
+			_, documented := operations[method]
+			if method == "head" && !documented {
+				_, documented = operations["get"]
+			}
+			assert.Truef(t, documented, "%s %s is missing from openapi.json", route.Method, route.Path)
+		}
+	})
+
+	t.Run("Every documented operation is registered", func(t *testing.T) {
+		registered := map[string]bool{}
+		for _, route := range setupDocsApp().GetRoutes(true) {
+			registered[route.Method+" "+routeParam.ReplaceAllString(route.Path, "{$1}")] = true
+		}
+
+		for path, operations := range spec.Paths {
+			for method := range operations {
+				key := strings.ToUpper(method) + " " + path
+				assert.Truef(t, registered[key], "%s is documented but not registered", key)
+			}
+		}
+	})
+
+	t.Run("Unversioned aliases are generated as deprecated", func(t *testing.T) {
+		var op struct {
+			OperationID string   `json:"operationId"`
+			Tags        []string `json:"tags"`
+			Deprecated  bool     `json:"deprecated"`
+			Responses   map[string]struct {
+				Ref     string                     `json:"$ref"`
+				Headers map[string]json.RawMessage `json:"headers"`
+			} `json:"responses"`
+		}
+		if err := json.Unmarshal(spec.Paths["/shorten"]["post"], &op); err != nil {
+			t.Fatalf("POST /shorten is not documented: %v", err)
+		}
+
+		assert.Equal(t, "createShortURLLegacy", op.OperationID)
+		assert.Equal(t, []string{"Deprecated"}, op.Tags)
+		assert.True(t, op.Deprecated)
+		assert.NotContains(t, spec.Paths["/api/v1/shorten"], "x-unversioned-alias")
+		for status, res := range op.Responses {
+			assert.Emptyf(t, res.Ref, "response %s is not inlined", status)
+			assert.Containsf(t, res.Headers, "Deprecation", "response %s", status)
+			assert.Containsf(t, res.Headers, "Link", "response %s", status)
+		}
+	})
+
+	t.Run("GET /openapi.json", func(t *testing.T) {
+		resp, err := setupDocsApp().Test(httptest.NewRequest("GET", "/openapi.json", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON)
+		assert.JSONEq(t, string(docs.OpenAPI), string(body))
+	})
+
+	t.Run("GET /docs", func(t *testing.T) {
+		resp, err := setupDocsApp().Test(httptest.NewRequest("GET", "/docs", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Get(fiber.HeaderContentType), fiber.MIMETextHTML)
+		assert.Contains(t, string(body), "/openapi.json")
+	})
+}
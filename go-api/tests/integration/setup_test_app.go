@@ -1,19 +1,111 @@
 package integration
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/middleware"
+	"github.com/nabilfikrisp/url-shortener/internal/common/response"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+	"github.com/nabilfikrisp/url-shortener/internal/docs"
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 )
 
+// test API keys accepted by apps built with setupTestApp
+const (
+	aliceKey = "alice-key"
+	bobKey   = "bob-key"
+)
+
 func setupTestApp(t *testing.T) *fiber.App {
+	return setupTestAppWithConfig(t, &config.Config{})
+}
+
+func setupTestAppWithConfig(t *testing.T, cfg *config.Config) *fiber.App {
 	db := SetupTestDB(t)
 
+	cfg.APIKeys = map[string]string{
+		aliceKey: "alice",
+		bobKey:   "bob",
+	}
+
 	// init handler + register routes
-	handler := url.InitURLHandler(db)
-	app := fiber.New()
-	url.RegisterRoutes(app, handler)
+	handler := url.InitURLHandler(db, cfg, nil, nil)
+	app := newFiberApp()
+	app.Use(middleware.APIKey(cfg.APIKeys))
+	docs.RegisterRoutes(app)
+	url.RegisterRoutes(app, handler, cfg)
 
 	return app
 }
+
+// newFiberApp builds an empty app answering errors the way main does.
+func newFiberApp() *fiber.App {
+	return fiber.New(fiber.Config{ErrorHandler: response.ErrorHandler})
+}
+
+// createShortURL posts body to /api/v1/shorten, optionally as apiKey, and returns the
+// created URL.
+func createShortURL(t *testing.T, app *fiber.App, body string, apiKey string) url.URLModel {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", url.APIPrefix+"/shorten", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set(middleware.APIKeyHeader, apiKey)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("expected 201 creating short URL, got %d", resp.StatusCode)
+	}
+
+	return decodeData[url.URLModel](t, resp)
+}
+
+// decodeData reads a success payload and decodes its data field into T.
+func decodeData[T any](t *testing.T, resp *http.Response) T {
+	t.Helper()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var successResp response.Success
+	if err := json.Unmarshal(respBody, &successResp); err != nil {
+		t.Fatal(err)
+	}
+
+	dataBytes, err := json.Marshal(successResp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data T
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// decodeError reads the error envelope of resp.
+func decodeError(t *testing.T, resp *http.Response) response.Error {
+	t.Helper()
+
+	var errResp response.Error
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatal(err)
+	}
+	return errResp
+}
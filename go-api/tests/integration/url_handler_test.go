@@ -4,14 +4,19 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 
 	// "strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/common/middleware"
 	"github.com/nabilfikrisp/url-shortener/internal/common/response"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -50,6 +55,44 @@ func TestURLHandler(t *testing.T) {
 			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
 		})
 
+		t.Run("Merges UTM fields into the destination", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/?q=go","utm":{"source":"newsletter","campaign":"spring"}}`, "")
+
+			assert.Equal(t, "https://www.google.com/?q=go&utm_campaign=spring&utm_source=newsletter", created.Original)
+			assert.Equal(t, url.UTM{Source: "newsletter", Campaign: "spring"}, created.UTM)
+		})
+
+		t.Run("UTM field too long", func(t *testing.T) {
+			app := setupTestApp(t)
+			body := `{"url":"https://www.google.com/","utm":{"campaign":"` + strings.Repeat("x", 256) + `"}}`
+			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+		})
+
+		t.Run("Invalid redirect type", func(t *testing.T) {
+			app := setupTestApp(t)
+			body := `{"url":"https://www.google.com/","redirect_type":303}`
+			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+		})
+
 		t.Run("Missing URL", func(t *testing.T) {
 			app := setupTestApp(t)
 			body := `{}`
@@ -99,12 +142,94 @@ func TestURLHandler(t *testing.T) {
 			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
 		})
 
+		t.Run("Alias", func(t *testing.T) {
+			t.Run("Success", func(t *testing.T) {
+				app := setupTestApp(t)
+				body := `{"url":"https://www.google.com/","alias":"spring-sale"}`
+
+				req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+
+				req = httptest.NewRequest("GET", "/spring-sale", nil)
+				resp, err = app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+				assert.Equal(t, "https://www.google.com/", resp.Header.Get("Location"))
+			})
+
+			t.Run("Invalid characters", func(t *testing.T) {
+				app := setupTestApp(t)
+				body := `{"url":"https://www.google.com/","alias":"spring sale!"}`
+
+				req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+			})
+
+			t.Run("Reserved word", func(t *testing.T) {
+				app := setupTestApp(t)
+				body := `{"url":"https://www.google.com/","alias":"stats"}`
+
+				req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+			})
+
+			t.Run("Already taken", func(t *testing.T) {
+				app := setupTestApp(t)
+
+				for i, target := range []string{"https://www.google.com/", "https://github.com/"} {
+					body := `{"url":"` + target + `","alias":"taken"}`
+					req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+
+					resp, err := app.Test(req, -1)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer resp.Body.Close()
+
+					if i == 0 {
+						assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+					} else {
+						assert.Equal(t, fiber.StatusConflict, resp.StatusCode)
+					}
+				}
+			})
+		})
+
 		t.Run("POST /shorten - Service Error", func(t *testing.T) {
 			// build app with mock service
-			app := fiber.New()
+			app := newFiberApp()
 			mockService := new(MockURLService)
 			mockService.On("CreateShortToken", mock.Anything).Return(nil, errors.New("db insert failed"))
-			h := url.NewURLHandler(mockService)
+			h := url.NewURLHandler(mockService, &config.Config{})
 			app.Post("/shorten", h.Create)
 
 			body := `{"url":"https://www.google.com/"}`
@@ -118,9 +243,84 @@ func TestURLHandler(t *testing.T) {
 			}
 			defer resp.Body.Close()
 
-			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+			assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+			errResp := decodeError(t, resp)
+			assert.Equal(t, apperror.CodeInternal, errResp.Code)
+			assert.NotContains(t, errResp.Error, "db insert failed")
+		})
+
+	})
+
+	t.Run("POST /shorten/batch", func(t *testing.T) {
+		t.Run("Success - results in input order", func(t *testing.T) {
+			app := setupTestApp(t)
+			body := `{"items":[{"url":"https://www.google.com/"},{"url":"invalid-url"},{"url":"https://github.com/","alias":"gh"},{}]}`
+
+			req := httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			respBody, _ := io.ReadAll(resp.Body)
+			var successResp struct {
+				Data []struct {
+					Index      int    `json:"index"`
+					ShortToken string `json:"short_token"`
+					Error      string `json:"error"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(respBody, &successResp); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Len(t, successResp.Data, 4)
+			assert.NotEmpty(t, successResp.Data[0].ShortToken)
+			assert.Equal(t, "please provide a valid URL", successResp.Data[1].Error)
+			assert.Equal(t, "gh", successResp.Data[2].ShortToken)
+			assert.Equal(t, "URL field is required", successResp.Data[3].Error)
+			for i, item := range successResp.Data {
+				assert.Equal(t, i, item.Index)
+			}
+		})
+
+		t.Run("Too many items", func(t *testing.T) {
+			app := newFiberApp()
+			h := url.NewURLHandler(new(MockURLService), &config.Config{BatchMaxURLs: 1})
+			app.Post("/shorten/batch", h.CreateBatch)
+
+			body := `{"items":[{"url":"https://www.google.com/"},{"url":"https://github.com/"}]}`
+			req := httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
 		})
 
+		t.Run("Empty items", func(t *testing.T) {
+			app := setupTestApp(t)
+
+			req := httptest.NewRequest("POST", "/shorten/batch", strings.NewReader(`{"items":[]}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		})
 	})
 
 	t.Run("GET /stats/:shortToken", func(t *testing.T) {
@@ -167,6 +367,124 @@ func TestURLHandler(t *testing.T) {
 			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
 		})
 
+		t.Run("Hides the destination of protected links from other callers", func(t *testing.T) {
+			protected := &url.URLModel{ID: 7, ShortToken: "secret", Original: "https://example.com/private", RawOriginal: "https://example.com/private", Owner: "alice", PasswordHash: "hash"}
+			mockService := new(MockURLService)
+			mockService.On("FindByShortToken", "secret").Return(protected, nil)
+			mockService.On("FindWithHistory", "secret").Return(protected, nil)
+			mockService.On("ClickBreakdowns", mock.Anything).Return(&url.ClickBreakdowns{}, nil)
+
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app := newFiberApp()
+			app.Use(middleware.APIKey(map[string]string{aliceKey: "alice", bobKey: "bob"}))
+			app.Get("/stats/:shortToken", h.FindByShortToken)
+			app.Get("/urls/:shortToken", h.FindWithHistory)
+
+			for _, path := range []string{"/stats/secret", "/urls/secret"} {
+				for apiKey, want := range map[string]string{"": "", bobKey: "", aliceKey: "https://example.com/private"} {
+					req := httptest.NewRequest("GET", path, nil)
+					if apiKey != "" {
+						req.Header.Set(middleware.APIKeyHeader, apiKey)
+					}
+					resp, err := app.Test(req, -1)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer resp.Body.Close()
+
+					assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+					data := decodeData[url.URLModel](t, resp)
+					assert.Equal(t, want, data.Original, path)
+					assert.Equal(t, want, data.RawOriginal, path)
+				}
+			}
+			// the stored link is left untouched
+			assert.Equal(t, "https://example.com/private", protected.Original)
+		})
+
+		t.Run("Breaks clicks down by device", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"devices"}`, "")
+
+			for _, ua := range []string{
+				"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+				"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			} {
+				req := httptest.NewRequest("GET", "/devices", nil)
+				req.Header.Set("User-Agent", ua)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			req := httptest.NewRequest("GET", "/stats/devices?breakdown=os,device&top=1", nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			stats := decodeData[struct {
+				ClickCount int                 `json:"click_count"`
+				Breakdowns url.ClickBreakdowns `json:"breakdowns"`
+			}](t, resp)
+			assert.Equal(t, 3, stats.ClickCount)
+			assert.Equal(t, int64(3), stats.Breakdowns.Total)
+			assert.Equal(t, []url.BreakdownItem{{Value: "iOS", Clicks: 2, Share: 0.6667}}, stats.Breakdowns.Top["os"])
+			assert.Equal(t, "mobile", stats.Breakdowns.Top["device"][0].Value)
+		})
+
+		t.Run("Breaks clicks down by referrer", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"sources"}`, "")
+
+			for _, referer := range []string{"https://www.google.com/", "https://www.google.de/", ""} {
+				req := httptest.NewRequest("GET", "/sources", nil)
+				if referer != "" {
+					req.Header.Set("Referer", referer)
+				}
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			req := httptest.NewRequest("GET", "/stats/sources?breakdown=referrer", nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			stats := decodeData[struct {
+				Breakdowns url.ClickBreakdowns `json:"breakdowns"`
+			}](t, resp)
+			assert.Equal(t, []url.BreakdownItem{
+				{Value: "google", Clicks: 2, Share: 0.6667},
+				{Value: "direct", Clicks: 1, Share: 0.3333},
+			}, stats.Breakdowns.Top["referrer"])
+		})
+
+		t.Run("Invalid breakdown", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"devices"}`, "")
+
+			req := httptest.NewRequest("GET", "/stats/devices?breakdown=shoe_size", nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		})
+
 		t.Run("Short Token Not Found", func(t *testing.T) {
 			app := setupTestApp(t)
 
@@ -182,10 +500,10 @@ func TestURLHandler(t *testing.T) {
 
 		t.Run("Service Error", func(t *testing.T) {
 			// Setup app with mock service
-			app := fiber.New()
+			app := newFiberApp()
 			mockService := new(MockURLService)
 			mockService.On("FindByShortToken", "error-token").Return(nil, errors.New("database connection failed"))
-			h := url.NewURLHandler(mockService)
+			h := url.NewURLHandler(mockService, &config.Config{})
 			app.Get("/stats/:shortToken", h.FindByShortToken)
 
 			req := httptest.NewRequest("GET", "/stats/error-token", nil)
@@ -195,50 +513,184 @@ func TestURLHandler(t *testing.T) {
 			}
 			defer resp.Body.Close()
 
-			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+			assert.Equal(t, apperror.CodeInternal, decodeError(t, resp).Code)
 		})
 
 	})
 
-	t.Run("GET /:shortToken - REDIRECT", func(t *testing.T) {
-		t.Run("Success - Redirects to original URL", func(t *testing.T) {
+	t.Run("GET /preview/:shortToken", func(t *testing.T) {
+		preview := &url.Preview{
+			ShortToken: "abc123",
+			Original:   "https://example.com/<script>",
+			ClickCount: 4,
+			CreatedAt:  time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+		}
+		newApp := func() *fiber.App {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("PreviewService", "abc123").Return(preview, nil)
+			mockService.On("PreviewService", "missing").Return(nil, url.ErrURLNotFound)
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app.Get("/preview/:shortToken", h.Preview)
+			return app
+		}
+
+		t.Run("Returns JSON by default", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", "/preview/abc123", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+			got := decodeData[url.Preview](t, resp)
+			assert.Equal(t, preview.Original, got.Original)
+			assert.Equal(t, 4, got.ClickCount)
+		})
+
+		t.Run("Renders HTML for browsers", func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/preview/abc123", nil)
+			req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+			resp, err := newApp().Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+			body, _ := io.ReadAll(resp.Body)
+			assert.Contains(t, string(body), "https://example.com/&lt;script&gt;")
+			assert.Contains(t, string(body), "1 March 2025")
+			assert.Contains(t, string(body), `href="/abc123"`)
+		})
+
+		t.Run("Short Token Not Found", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", "/preview/missing", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("GET /stats/:shortToken/timeseries", func(t *testing.T) {
+		t.Run("Success", func(t *testing.T) {
 			app := setupTestApp(t)
-			body := `{"url":"https://www.google.com/"}`
-			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
-			req.Header.Set("Content-Type", "application/json")
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"series"}`, "")
+
+			for range 2 {
+				resp, err := app.Test(httptest.NewRequest("GET", "/series", nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
 
+			req := httptest.NewRequest("GET", "/stats/series/timeseries?interval=hour&tz=Asia/Jakarta", nil)
 			resp, err := app.Test(req, -1)
 			if err != nil {
 				t.Fatal(err)
 			}
 			defer resp.Body.Close()
 
-			respBody, _ := io.ReadAll(resp.Body)
-			var successResp response.Success
-			if err := json.Unmarshal(respBody, &successResp); err != nil {
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			series := decodeData[url.Timeseries](t, resp)
+			assert.Equal(t, int64(2), series.Total)
+			assert.Equal(t, "Asia/Jakarta", series.Timezone)
+			assert.GreaterOrEqual(t, len(series.Buckets), 24)
+		})
+
+		t.Run("Invalid query parameters", func(t *testing.T) {
+			app := newFiberApp()
+			h := url.NewURLHandler(new(MockURLService), &config.Config{})
+			app.Get("/stats/:shortToken/timeseries", h.ClickTimeseries)
+
+			for _, query := range []string{"interval=month", "tz=Mars/Olympus", "tz=Local", "from=yesterday"} {
+				req := httptest.NewRequest("GET", "/stats/abc123/timeseries?"+query, nil)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, query)
+			}
+		})
+
+		t.Run("Short Token Not Found", func(t *testing.T) {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("ClickTimeseries", mock.Anything).Return(nil, url.ErrURLNotFound)
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app.Get("/stats/:shortToken/timeseries", h.ClickTimeseries)
+
+			req := httptest.NewRequest("GET", "/stats/nonexistent/timeseries", nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
 				t.Fatal(err)
 			}
+			defer resp.Body.Close()
 
-			dataBytes, _ := json.Marshal(successResp.Data)
-			var created url.URLModel
-			if err := json.Unmarshal(dataBytes, &created); err != nil {
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("GET /stats/:shortToken/visitors", func(t *testing.T) {
+		t.Run("Counts a returning visitor once", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"visits"}`, "")
+
+			for _, agent := range []string{"curl/8.0", "curl/8.0", "Wget/1.21"} {
+				req := httptest.NewRequest("GET", "/visits", nil)
+				req.Header.Set("User-Agent", agent)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/stats/visits/visitors", nil), -1)
+			if err != nil {
 				t.Fatal(err)
 			}
+			defer resp.Body.Close()
 
-			req = httptest.NewRequest("GET", "/"+created.ShortToken, nil)
-			resp, err = app.Test(req, -1)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			visitors := decodeData[url.UniqueVisitors](t, resp)
+			assert.Equal(t, uint64(2), visitors.Total)
+			assert.Len(t, visitors.Days, 30)
+			assert.Equal(t, uint64(2), visitors.Days[29].Visitors)
+		})
+
+		t.Run("Invalid query parameters", func(t *testing.T) {
+			app := newFiberApp()
+			h := url.NewURLHandler(new(MockURLService), &config.Config{})
+			app.Get("/stats/:shortToken/visitors", h.UniqueVisitors)
+
+			req := httptest.NewRequest("GET", "/stats/abc123/visitors?from=yesterday", nil)
+			resp, err := app.Test(req, -1)
 			if err != nil {
 				t.Fatal(err)
 			}
 			defer resp.Body.Close()
 
-			assert.Equal(t, fiber.StatusFound, resp.StatusCode)
-			assert.Equal(t, "https://www.google.com/", resp.Header.Get("Location"))
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
 		})
 
-		t.Run("Failure - Short token not found", func(t *testing.T) {
-			app := setupTestApp(t)
-			req := httptest.NewRequest("GET", "/nonexistent", nil)
+		t.Run("Short Token Not Found", func(t *testing.T) {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("UniqueVisitors", mock.Anything).Return(nil, url.ErrURLNotFound)
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app.Get("/stats/:shortToken/visitors", h.UniqueVisitors)
+
+			req := httptest.NewRequest("GET", "/stats/nonexistent/visitors", nil)
 			resp, err := app.Test(req, -1)
 			if err != nil {
 				t.Fatal(err)
@@ -246,16 +698,1024 @@ func TestURLHandler(t *testing.T) {
 			defer resp.Body.Close()
 
 			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		})
+	})
 
-			respBody, _ := io.ReadAll(resp.Body)
+	t.Run("GET /stats/:shortToken/export", func(t *testing.T) {
+		t.Run("Streams click events as CSV", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"exported"}`, aliceKey)
 
-			var errResp response.Error
-			if err := json.Unmarshal(respBody, &errResp); err != nil {
+			for _, agent := range []string{"curl/8.0", "Googlebot/2.1"} {
+				req := httptest.NewRequest("GET", "/exported", nil)
+				req.Header.Set("User-Agent", agent)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			req := httptest.NewRequest("GET", "/stats/exported/export", nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
 				t.Fatal(err)
 			}
+			defer resp.Body.Close()
 
-			assert.Contains(t, errResp.Message, "not found")
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+			assert.Contains(t, resp.Header.Get("Content-Disposition"), `filename="exported-events.csv"`)
+
+			body, _ := io.ReadAll(resp.Body)
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			assert.Len(t, lines, 3)
+			assert.True(t, strings.HasPrefix(lines[0], "short_token,occurred_at,"))
+			assert.True(t, strings.HasSuffix(lines[1], ",false"))
+			assert.True(t, strings.HasSuffix(lines[2], ",true"))
 		})
-	})
 
+		t.Run("Streams daily counts as NDJSON", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"daily"}`, "")
+
+			for range 3 {
+				resp, err := app.Test(httptest.NewRequest("GET", "/daily", nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/stats/daily/export?format=ndjson&data=daily", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+			var count url.DailyClickCount
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&count))
+			assert.Equal(t, "daily", count.ShortToken)
+			assert.Equal(t, int64(3), count.Clicks)
+		})
+
+		t.Run("Invalid query parameters", func(t *testing.T) {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("ExportStats", mock.Anything).Return(nil, url.ErrInvalidStatsQuery)
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app.Get("/stats/:shortToken/export", h.ExportStats)
+
+			for _, query := range []string{"data=daily&format=xlsx", "data=daily&from=yesterday"} {
+				req := httptest.NewRequest("GET", "/stats/abc123/export?"+query, nil)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, query)
+			}
+		})
+
+		t.Run("Short Token Not Found", func(t *testing.T) {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("ExportStats", mock.Anything).Return(nil, url.ErrURLNotFound)
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app.Get("/stats/:shortToken/export", h.ExportStats)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/stats/nonexistent/export?data=daily", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("GET /stats/:shortToken/export - click events", func(t *testing.T) {
+		t.Run("Require an API key", func(t *testing.T) {
+			app := newFiberApp()
+			app.Get("/stats/:shortToken/export", url.NewURLHandler(new(MockURLService), &config.Config{}).ExportStats)
+
+			for _, query := range []string{"", "?data=events"} {
+				resp, err := app.Test(httptest.NewRequest("GET", "/stats/abc123/export"+query, nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode, query)
+			}
+		})
+
+		t.Run("Are refused to other owners", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"private"}`, aliceKey)
+
+			req := httptest.NewRequest("GET", "/stats/private/export", nil)
+			req.Header.Set(middleware.APIKeyHeader, bobKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+		})
+	})
+
+	t.Run("GET /stats/export", func(t *testing.T) {
+		t.Run("Exports only the caller's URLs", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"alice-link"}`, aliceKey)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"bob-link"}`, bobKey)
+
+			for _, token := range []string{"alice-link", "bob-link"} {
+				resp, err := app.Test(httptest.NewRequest("GET", "/"+token, nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			req := httptest.NewRequest("GET", "/stats/export?data=daily", nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Contains(t, resp.Header.Get("Content-Disposition"), `filename="account-daily.csv"`)
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Contains(t, string(body), "alice-link,")
+			assert.NotContains(t, string(body), "bob-link")
+		})
+
+		t.Run("Filters by campaign", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"spring-link","utm":{"campaign":"spring"}}`, aliceKey)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"autumn-link","utm":{"campaign":"autumn"}}`, aliceKey)
+
+			for _, token := range []string{"spring-link", "autumn-link"} {
+				resp, err := app.Test(httptest.NewRequest("GET", "/"+token, nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			req := httptest.NewRequest("GET", "/stats/export?campaign=spring", nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Contains(t, string(body), "spring-link,")
+			assert.NotContains(t, string(body), "autumn-link")
+		})
+
+		t.Run("Requires an API key", func(t *testing.T) {
+			app := setupTestApp(t)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/stats/export", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+		})
+	})
+
+	t.Run("GET /api/v1/urls", func(t *testing.T) {
+		list := func(t *testing.T, app *fiber.App, query string) url.URLPage {
+			t.Helper()
+
+			req := httptest.NewRequest("GET", url.APIPrefix+"/urls?"+query, nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			return decodeData[url.URLPage](t, resp)
+		}
+
+		t.Run("Pages through only the caller's URLs", func(t *testing.T) {
+			app := setupTestApp(t)
+			for _, alias := range []string{"first", "second", "third"} {
+				createShortURL(t, app, `{"url":"https://www.google.com/","alias":"`+alias+`"}`, aliceKey)
+			}
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"bobs"}`, bobKey)
+
+			page := list(t, app, "limit=2")
+			assert.Len(t, page.Items, 2)
+			assert.Equal(t, "third", page.Items[0].ShortToken)
+			assert.NotEmpty(t, page.NextCursor)
+
+			next := list(t, app, "limit=2&cursor="+page.NextCursor)
+			assert.Len(t, next.Items, 1)
+			assert.Equal(t, "first", next.Items[0].ShortToken)
+			assert.Empty(t, next.NextCursor)
+		})
+
+		t.Run("Filters by tag, domain and state", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://blog.example.com/post","alias":"tagged","tags":["Launch"]}`, aliceKey)
+			createShortURL(t, app, `{"url":"https://other.com/","alias":"untagged"}`, aliceKey)
+			createShortURL(t, app, `{"url":"https://other.com/","alias":"used-up","max_clicks":1}`, aliceKey)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/used-up", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			tagged := list(t, app, "tag=launch")
+			assert.Len(t, tagged.Items, 1)
+			assert.Equal(t, url.Tags{"launch"}, tagged.Items[0].Tags)
+
+			byDomain := list(t, app, "domain=example.com")
+			assert.Len(t, byDomain.Items, 1)
+			assert.Equal(t, "tagged", byDomain.Items[0].ShortToken)
+
+			expired := list(t, app, "state=expired")
+			assert.Len(t, expired.Items, 1)
+			assert.Equal(t, "used-up", expired.Items[0].ShortToken)
+			assert.Len(t, list(t, app, "state=active").Items, 2)
+		})
+
+		t.Run("Passes query parameters to the service", func(t *testing.T) {
+			mockService := new(MockURLService)
+			mockService.On("ListURLs", url.ListParams{
+				Owner:     "alice",
+				Domain:    "example.com",
+				Tag:       "launch",
+				State:     url.ListActive,
+				Sort:      url.ListByClickCount,
+				Ascending: true,
+				Limit:     5,
+				Cursor:    "abc",
+			}).Return(&url.URLPage{Items: []*url.URLModel{}}, nil)
+
+			app := newFiberApp()
+			app.Use(middleware.APIKey(map[string]string{aliceKey: "alice"}))
+			app.Get("/urls", url.NewURLHandler(mockService, &config.Config{}).List)
+
+			req := httptest.NewRequest("GET", "/urls?domain=example.com&tag=launch&state=active&sort=click_count&order=asc&limit=5&cursor=abc", nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			mockService.AssertExpectations(t)
+		})
+
+		t.Run("Invalid query parameters", func(t *testing.T) {
+			app := newFiberApp()
+			app.Use(middleware.APIKey(map[string]string{aliceKey: "alice"}))
+			app.Get("/urls", url.NewURLHandler(new(MockURLService), &config.Config{}).List)
+
+			for _, query := range []string{"sort=original", "order=up", "state=deleted", "limit=0", "from=yesterday"} {
+				req := httptest.NewRequest("GET", "/urls?"+query, nil)
+				req.Header.Set(middleware.APIKeyHeader, aliceKey)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, query)
+			}
+		})
+
+		t.Run("Requires an API key", func(t *testing.T) {
+			app := newFiberApp()
+			app.Get("/urls", url.NewURLHandler(new(MockURLService), &config.Config{}).List)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/urls", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+			assert.Equal(t, apperror.CodeUnauthorized, decodeError(t, resp).Code)
+		})
+	})
+
+	t.Run("GET /urls/:shortToken", func(t *testing.T) {
+		newApp := func() *fiber.App {
+			mockService := new(MockURLService)
+			mockService.On("FindWithHistory", "abc123").Return(&url.URLModel{
+				ShortToken: "abc123",
+				Original:   "https://example.com/new",
+				Owner:      "alice",
+				History:    []url.URLDestinationHistory{{Original: "https://example.com/old", ChangedBy: "alice"}},
+			}, nil)
+			app := newFiberApp()
+			app.Use(middleware.APIKey(map[string]string{aliceKey: "alice", bobKey: "bob"}))
+			app.Get("/urls/:shortToken", url.NewURLHandler(mockService, &config.Config{}).FindWithHistory)
+			return app
+		}
+		get := func(t *testing.T, apiKey string) map[string]any {
+			t.Helper()
+
+			req := httptest.NewRequest("GET", "/urls/abc123", nil)
+			if apiKey != "" {
+				req.Header.Set(middleware.APIKeyHeader, apiKey)
+			}
+			resp, err := newApp().Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			return decodeData[map[string]any](t, resp)
+		}
+
+		t.Run("Owner sees history and owner", func(t *testing.T) {
+			data := get(t, aliceKey)
+
+			assert.Equal(t, "alice", data["owner"])
+			assert.Len(t, data["history"], 1)
+		})
+
+		t.Run("Other callers see neither", func(t *testing.T) {
+			for _, apiKey := range []string{"", bobKey} {
+				data := get(t, apiKey)
+
+				assert.Equal(t, "https://example.com/new", data["original"])
+				assert.NotContains(t, data, "owner")
+				assert.NotContains(t, data, "history")
+			}
+		})
+	})
+
+	t.Run("PATCH /urls/:shortToken", func(t *testing.T) {
+		t.Run("Success - retargets and keeps history", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+
+			req := httptest.NewRequest("PATCH", "/urls/"+created.ShortToken, strings.NewReader(`{"url":"https://github.com/"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			req = httptest.NewRequest("GET", "/"+created.ShortToken, nil)
+			resp, err = app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, "https://github.com/", resp.Header.Get("Location"))
+
+			req = httptest.NewRequest("GET", "/urls/"+created.ShortToken, nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err = app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			respBody, _ := io.ReadAll(resp.Body)
+			var successResp struct {
+				Data url.URLModel `json:"data"`
+			}
+			if err := json.Unmarshal(respBody, &successResp); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Len(t, successResp.Data.History, 1)
+			assert.Equal(t, "https://www.google.com/", successResp.Data.History[0].Original)
+			assert.Equal(t, "alice", successResp.Data.History[0].ChangedBy)
+		})
+
+		t.Run("Missing API key", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+
+			req := httptest.NewRequest("PATCH", "/urls/"+created.ShortToken, strings.NewReader(`{"url":"https://github.com/"}`))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+		})
+
+		t.Run("Not the owner", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+
+			req := httptest.NewRequest("PATCH", "/urls/"+created.ShortToken, strings.NewReader(`{"url":"https://github.com/"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(middleware.APIKeyHeader, bobKey)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+		})
+
+		t.Run("Short token not found", func(t *testing.T) {
+			app := setupTestApp(t)
+
+			req := httptest.NewRequest("PATCH", "/urls/nonexistent", strings.NewReader(`{"url":"https://github.com/"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("DELETE /urls/:shortToken and restore", func(t *testing.T) {
+		t.Run("Deleted link stops redirecting until restored", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+
+			req := httptest.NewRequest("DELETE", "/urls/"+created.ShortToken, nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			req = httptest.NewRequest("GET", "/"+created.ShortToken, nil)
+			resp, err = app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+			req = httptest.NewRequest("POST", "/urls/"+created.ShortToken+"/restore", nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err = app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			req = httptest.NewRequest("GET", "/"+created.ShortToken, nil)
+			resp, err = app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+		})
+
+		t.Run("Deleted token is not reissued", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+
+			req := httptest.NewRequest("DELETE", "/urls/"+created.ShortToken, nil)
+			req.Header.Set(middleware.APIKeyHeader, aliceKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			recreated := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+			assert.NotEqual(t, created.ShortToken, recreated.ShortToken)
+		})
+
+		t.Run("Not the owner", func(t *testing.T) {
+			app := setupTestApp(t)
+			created := createShortURL(t, app, `{"url":"https://www.google.com/"}`, aliceKey)
+
+			req := httptest.NewRequest("DELETE", "/urls/"+created.ShortToken, nil)
+			req.Header.Set(middleware.APIKeyHeader, bobKey)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+		})
+	})
+
+	t.Run("GET /:shortToken - REDIRECT", func(t *testing.T) {
+		t.Run("Success - Redirects to original URL", func(t *testing.T) {
+			app := setupTestApp(t)
+			body := `{"url":"https://www.google.com/"}`
+			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			respBody, _ := io.ReadAll(resp.Body)
+			var successResp response.Success
+			if err := json.Unmarshal(respBody, &successResp); err != nil {
+				t.Fatal(err)
+			}
+
+			dataBytes, _ := json.Marshal(successResp.Data)
+			var created url.URLModel
+			if err := json.Unmarshal(dataBytes, &created); err != nil {
+				t.Fatal(err)
+			}
+
+			req = httptest.NewRequest("GET", "/"+created.ShortToken, nil)
+			resp, err = app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+			assert.Equal(t, "https://www.google.com/", resp.Header.Get("Location"))
+		})
+
+		t.Run("Failure - Click limit reached returns 410 Gone", func(t *testing.T) {
+			app := setupTestApp(t)
+			body := `{"url":"https://www.google.com/","alias":"one-shot","max_clicks":1}`
+			req := httptest.NewRequest("POST", "/shorten", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+
+			for _, want := range []int{fiber.StatusFound, fiber.StatusGone} {
+				req = httptest.NewRequest("GET", "/one-shot", nil)
+				resp, err = app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, want, resp.StatusCode)
+			}
+		})
+
+		t.Run("Bots are redirected but counted separately", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"shared"}`, "")
+
+			requests := []*http.Request{
+				httptest.NewRequest("GET", "/shared", nil),
+				httptest.NewRequest("GET", "/shared", nil),
+				httptest.NewRequest("GET", "/shared", nil),
+			}
+			requests[0].Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+			requests[1].Header.Set("Sec-Purpose", "prefetch")
+
+			for _, req := range requests {
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+				assert.Equal(t, "https://www.google.com/", resp.Header.Get("Location"))
+			}
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/stats/shared?breakdown=browser&bots=true", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			stats := decodeData[struct {
+				ClickCount    int                 `json:"click_count"`
+				BotClickCount int                 `json:"bot_click_count"`
+				Breakdowns    url.ClickBreakdowns `json:"breakdowns"`
+			}](t, resp)
+			assert.Equal(t, 1, stats.ClickCount)
+			assert.Equal(t, 2, stats.BotClickCount)
+			assert.Equal(t, int64(2), stats.Breakdowns.Total)
+		})
+
+		t.Run("Expired link redirects to configured page", func(t *testing.T) {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("RedirectService", "expired", mock.Anything).Return(nil, url.ErrLinkExpired)
+			h := url.NewURLHandler(mockService, &config.Config{ExpiredRedirectURL: "https://example.com/expired"})
+			app.Get("/:shortToken", h.RedirectToOriginal)
+
+			req := httptest.NewRequest("GET", "/expired", nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+			assert.Equal(t, "https://example.com/expired", resp.Header.Get("Location"))
+		})
+
+		t.Run("Uses the redirect type and matching cache headers", func(t *testing.T) {
+			maxClicks := 10
+			cases := []struct {
+				name         string
+				url          *url.URLModel
+				cfg          config.Config
+				status       int
+				cacheControl string
+			}{
+				{"default is a temporary redirect", &url.URLModel{}, config.Config{}, fiber.StatusFound, "no-store"},
+				{"configured default", &url.URLModel{}, config.Config{RedirectType: fiber.StatusMovedPermanently}, fiber.StatusMovedPermanently, "public, max-age=86400"},
+				{"link type overrides default", &url.URLModel{RedirectType: fiber.StatusPermanentRedirect}, config.Config{RedirectType: fiber.StatusFound, RedirectCacheMaxAge: time.Hour}, fiber.StatusPermanentRedirect, "public, max-age=3600"},
+				{"307 is not cached", &url.URLModel{RedirectType: fiber.StatusTemporaryRedirect}, config.Config{}, fiber.StatusTemporaryRedirect, "no-store"},
+				{"permanent link with a click limit is not cached", &url.URLModel{RedirectType: fiber.StatusMovedPermanently, MaxClicks: &maxClicks}, config.Config{}, fiber.StatusMovedPermanently, "no-store"},
+			}
+
+			for _, tc := range cases {
+				t.Run(tc.name, func(t *testing.T) {
+					tc.url.ShortToken, tc.url.Original = "abc123", "https://example.com/"
+					app := newFiberApp()
+					mockService := new(MockURLService)
+					mockService.On("RedirectService", "abc123", mock.Anything).Return(tc.url, nil)
+					h := url.NewURLHandler(mockService, &tc.cfg)
+					app.Get("/:shortToken", h.RedirectToOriginal)
+
+					resp, err := app.Test(httptest.NewRequest("GET", "/abc123", nil), -1)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer resp.Body.Close()
+
+					assert.Equal(t, tc.status, resp.StatusCode)
+					assert.Equal(t, "https://example.com/", resp.Header.Get("Location"))
+					assert.Equal(t, tc.cacheControl, resp.Header.Get("Cache-Control"))
+				})
+			}
+		})
+
+		t.Run("Stores the redirect type chosen on create", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"forever","redirect_type":301}`, "")
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/forever", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusMovedPermanently, resp.StatusCode)
+			assert.Contains(t, resp.Header.Get("Cache-Control"), "public")
+		})
+
+		t.Run("HEAD answers like GET without counting", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"checked"}`, "")
+
+			for range 2 {
+				resp, err := app.Test(httptest.NewRequest("HEAD", "/checked", nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+				assert.Equal(t, "https://www.google.com/", resp.Header.Get("Location"))
+			}
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/stats/checked", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			stats := decodeData[url.URLModel](t, resp)
+			assert.Equal(t, 0, stats.ClickCount)
+			assert.Equal(t, 0, stats.BotClickCount)
+		})
+
+		t.Run("HEAD uses the redirect checks", func(t *testing.T) {
+			app := newFiberApp()
+			mockService := new(MockURLService)
+			mockService.On("ResolveService", "expired").Return(nil, url.ErrLinkExpired)
+			mockService.On("ResolveService", "missing").Return(nil, url.ErrURLNotFound)
+			h := url.NewURLHandler(mockService, &config.Config{})
+			app.Head("/:shortToken", h.HeadRedirect)
+			app.Get("/:shortToken", h.RedirectToOriginal)
+
+			for token, want := range map[string]int{"expired": fiber.StatusGone, "missing": fiber.StatusNotFound} {
+				resp, err := app.Test(httptest.NewRequest("HEAD", "/"+token, nil), -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				assert.Equal(t, want, resp.StatusCode, token)
+			}
+			mockService.AssertNotCalled(t, "RedirectService", mock.Anything, mock.Anything)
+		})
+
+		t.Run("Password protected link", func(t *testing.T) {
+			app := setupTestApp(t)
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"secret-doc","password":"s3cret"}`, "")
+
+			t.Run("GET serves password form", func(t *testing.T) {
+				req := httptest.NewRequest("GET", "/secret-doc", nil)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+				assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+				assert.Empty(t, resp.Header.Get("Location"))
+			})
+
+			t.Run("Wrong password is refused", func(t *testing.T) {
+				req := httptest.NewRequest("POST", "/secret-doc", strings.NewReader("password=wrong"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+			})
+
+			t.Run("Correct password redirects", func(t *testing.T) {
+				req := httptest.NewRequest("POST", "/secret-doc", strings.NewReader("password=s3cret"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+				assert.Equal(t, "https://www.google.com/", resp.Header.Get("Location"))
+			})
+		})
+
+		t.Run("Password attempts are rate limited per IP", func(t *testing.T) {
+			app := setupTestAppWithConfig(t, &config.Config{PasswordMaxAttempts: 2})
+			createShortURL(t, app, `{"url":"https://www.google.com/","alias":"secret-doc","password":"s3cret"}`, "")
+
+			statuses := []int{}
+			for range 3 {
+				req := httptest.NewRequest("POST", "/secret-doc", strings.NewReader("password=wrong"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				statuses = append(statuses, resp.StatusCode)
+			}
+
+			assert.Equal(t, []int{fiber.StatusForbidden, fiber.StatusForbidden, fiber.StatusTooManyRequests}, statuses)
+		})
+
+		t.Run("Failure - Short token not found", func(t *testing.T) {
+			app := setupTestApp(t)
+			req := httptest.NewRequest("GET", "/nonexistent", nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+			respBody, _ := io.ReadAll(resp.Body)
+
+			var errResp response.Error
+			if err := json.Unmarshal(respBody, &errResp); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Contains(t, errResp.Message, "not found")
+		})
+	})
+
+	t.Run("API versioning", func(t *testing.T) {
+		newApp := func() *fiber.App {
+			cfg := &config.Config{}
+			mockService := new(MockURLService)
+			mockService.On("FindWithHistory", "abc123").Return(&url.URLModel{ShortToken: "abc123", Original: "https://example.com"}, nil)
+			mockService.On("RedirectService", "statsfoo", mock.Anything).Return(nil, url.ErrURLNotFound)
+			app := newFiberApp()
+			url.RegisterRoutes(app, url.NewURLHandler(mockService, cfg), cfg)
+			return app
+		}
+
+		t.Run("Serves the management API under /api/v1", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", url.APIPrefix+"/urls/abc123", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Deprecation"))
+			assert.Equal(t, "https://example.com", decodeData[url.URLModel](t, resp).Original)
+		})
+
+		t.Run("Marks unversioned paths as deprecated", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", "/urls/abc123?x=1", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Regexp(t, `^@\d+$`, resp.Header.Get("Deprecation"))
+			assert.Equal(t, `</api/v1/urls/abc123?x=1>; rel="successor-version"`, resp.Header.Get("Link"))
+		})
+
+		t.Run("Leaves redirects undeprecated", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", "/statsfoo", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("Deprecation"))
+		})
+	})
+
+	t.Run("Error responses", func(t *testing.T) {
+		newApp := func() *fiber.App {
+			cfg := &config.Config{}
+			mockService := new(MockURLService)
+			mockService.On("FindWithHistory", "missing").Return(nil, url.ErrURLNotFound)
+			app := newFiberApp()
+			url.RegisterRoutes(app, url.NewURLHandler(mockService, cfg), cfg)
+			return app
+		}
+		post := func(path string, body string, accept string) *http.Response {
+			req := httptest.NewRequest("POST", path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			resp, err := newApp().Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { resp.Body.Close() })
+			return resp
+		}
+
+		t.Run("Not found has a stable code", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", url.APIPrefix+"/urls/missing", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+			errResp := decodeError(t, resp)
+			assert.Equal(t, apperror.CodeNotFound, errResp.Code)
+			assert.Equal(t, "Short URL not found", errResp.Message)
+		})
+
+		t.Run("Unknown API keys have a stable code", func(t *testing.T) {
+			for _, accept := range []string{"", response.MIMEProblemJSON} {
+				app := newFiberApp()
+				app.Use(middleware.APIKey(map[string]string{aliceKey: "alice"}))
+				app.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set(middleware.APIKeyHeader, "not-a-key")
+				if accept != "" {
+					req.Header.Set("Accept", accept)
+				}
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+
+				assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+				if accept == "" {
+					assert.Equal(t, apperror.CodeUnauthorized, decodeError(t, resp).Code)
+					continue
+				}
+				assert.Equal(t, response.MIMEProblemJSON, resp.Header.Get(fiber.HeaderContentType))
+				var problem response.Problem
+				if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, response.ProblemType(apperror.CodeUnauthorized), problem.Type)
+				assert.Equal(t, fiber.StatusUnauthorized, problem.Status)
+			}
+		})
+
+		t.Run("Validation errors name the field", func(t *testing.T) {
+			resp := post(url.APIPrefix+"/shorten", `{"url":"https://example.org","alias":"a b"}`, "")
+
+			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+			errResp := decodeError(t, resp)
+			assert.Equal(t, apperror.CodeValidation, errResp.Code)
+			if assert.Len(t, errResp.Fields, 1) {
+				assert.Equal(t, "alias", errResp.Fields[0].Field)
+			}
+		})
+
+		t.Run("Invalid and own domain URLs", func(t *testing.T) {
+			for body, code := range map[string]apperror.Code{
+				`{"url":"not a url"}`:               apperror.CodeInvalidURL,
+				`{"url":"http://example.com/page"}`: apperror.CodeOwnDomain,
+				`{"url":`:                           apperror.CodeInvalidRequest,
+			} {
+				resp := post(url.APIPrefix+"/shorten", body, "")
+				assert.Equal(t, code.Status(), resp.StatusCode, body)
+				assert.Equal(t, code, decodeError(t, resp).Code, body)
+			}
+		})
+
+		t.Run("Batch results carry codes", func(t *testing.T) {
+			resp := post(url.APIPrefix+"/shorten/batch", `{"items":[{"url":"not a url"},{"url":""}]}`, "")
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			results := decodeData[[]struct {
+				Code apperror.Code `json:"code"`
+			}](t, resp)
+			if assert.Len(t, results, 2) {
+				assert.Equal(t, apperror.CodeInvalidURL, results[0].Code)
+				assert.Equal(t, apperror.CodeInvalidRequest, results[1].Code)
+			}
+		})
+
+		t.Run("Problem details on request", func(t *testing.T) {
+			resp := post(url.APIPrefix+"/shorten", `{"url":"https://example.org","max_clicks":0}`, response.MIMEProblemJSON)
+
+			assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+			assert.Equal(t, response.MIMEProblemJSON, resp.Header.Get(fiber.HeaderContentType))
+
+			var problem response.Problem
+			if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, response.ProblemType(apperror.CodeValidation), problem.Type)
+			assert.Equal(t, fiber.StatusUnprocessableEntity, problem.Status)
+			assert.Equal(t, url.APIPrefix+"/shorten", problem.Instance)
+			assert.Equal(t, []apperror.FieldError{{Field: "max_clicks", Message: "max_clicks must be at least 1"}}, problem.Errors)
+		})
+
+		t.Run("Unknown routes use the same envelope", func(t *testing.T) {
+			resp, err := newApp().Test(httptest.NewRequest("GET", "/no/such/route", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, apperror.CodeNotFound, decodeError(t, resp).Code)
+		})
+	})
 }
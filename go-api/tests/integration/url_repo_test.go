@@ -1,8 +1,11 @@
 package integration
 
 import (
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/nabilfikrisp/url-shortener/internal/common/hll"
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
@@ -49,6 +52,38 @@ func TestURLRepo(t *testing.T) {
 		})
 	})
 
+	t.Run("CreateBatch", func(t *testing.T) {
+		t.Run("Success", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			err := repo.CreateBatch([]*url.URLModel{
+				{Original: "https://one.com", ShortToken: "one"},
+				{Original: "https://two.com", ShortToken: "two"},
+			})
+			assert.NoError(t, err)
+
+			found, err := repo.FindByShortTokens([]string{"one", "two", "missing"})
+			assert.NoError(t, err)
+			assert.Len(t, found, 2)
+		})
+
+		t.Run("Rolls back on duplicate short token", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			err := repo.CreateBatch([]*url.URLModel{
+				{Original: "https://one.com", ShortToken: "dup"},
+				{Original: "https://two.com", ShortToken: "dup"},
+			})
+			assert.Error(t, err)
+
+			var count int64
+			db.Model(&url.URLModel{}).Count(&count)
+			assert.Equal(t, int64(0), count)
+		})
+	})
+
 	t.Run("FindByShortToken", func(t *testing.T) {
 		t.Run("Found", func(t *testing.T) {
 			db := SetupTestDB(t)
@@ -92,76 +127,342 @@ func TestURLRepo(t *testing.T) {
 		})
 	})
 
-	t.Run("IncrementClickCount", func(t *testing.T) {
-		t.Run("Success", func(t *testing.T) {
+	t.Run("UpdateDestination", func(t *testing.T) {
+		t.Run("Updates URL and records history", func(t *testing.T) {
 			db := SetupTestDB(t)
 			repo := url.NewURLRepo(db)
 
-			// Create a URL first
 			testURL := &url.URLModel{
-				Original:   "https://github.com",
-				ShortToken: "gh123",
-				ClickCount: 10,
+				Original:   "https://old.com",
+				ShortToken: "move123",
+				Owner:      "alice",
 			}
 			err := repo.Create(testURL)
 			assert.NoError(t, err)
 
-			// Increment click count
-			rowsAffected, err := repo.IncrementClickCount("gh123")
+			history := &url.URLDestinationHistory{
+				URLID:     testURL.ID,
+				Original:  testURL.Original,
+				ChangedBy: "alice",
+				ChangedAt: time.Now(),
+			}
+			testURL.Original = "https://new.com"
+			err = repo.UpdateDestination(testURL, history)
+			assert.NoError(t, err)
+
+			found, err := repo.FindByShortTokenWithHistory("move123")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://new.com", found.Original)
+			assert.Len(t, found.History, 1)
+			assert.Equal(t, "https://old.com", found.History[0].Original)
+		})
+	})
+
+	t.Run("SoftDelete", func(t *testing.T) {
+		t.Run("Hides URL until restored", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			testURL := &url.URLModel{Original: "https://example.com", ShortToken: "del123"}
+			assert.NoError(t, repo.Create(testURL))
+			assert.NoError(t, repo.SoftDelete(testURL))
+
+			found, err := repo.FindByShortToken("del123")
+			assert.NoError(t, err)
+			assert.Nil(t, found)
+
+			deleted, err := repo.FindDeletedByShortToken("del123")
+			assert.NoError(t, err)
+			assert.NotNil(t, deleted)
+
+			assert.NoError(t, repo.Restore(deleted))
+
+			found, err = repo.FindByShortToken("del123")
+			assert.NoError(t, err)
+			assert.NotNil(t, found)
+		})
+
+		t.Run("Token stays taken", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			testURL := &url.URLModel{Original: "https://example.com", ShortToken: "del123"}
+			assert.NoError(t, repo.Create(testURL))
+			assert.NoError(t, repo.SoftDelete(testURL))
+
+			err := repo.Create(&url.URLModel{Original: "https://other.com", ShortToken: "del123"})
+			assert.ErrorIs(t, err, url.ErrShortTokenTaken)
+		})
+	})
+
+	t.Run("PurgeDeleted", func(t *testing.T) {
+		t.Run("Removes only URLs deleted before cutoff", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			old := &url.URLModel{Original: "https://old.com", ShortToken: "old123"}
+			recent := &url.URLModel{Original: "https://recent.com", ShortToken: "recent123"}
+			assert.NoError(t, repo.Create(old))
+			assert.NoError(t, repo.Create(recent))
+			assert.NoError(t, repo.SoftDelete(old))
+			assert.NoError(t, repo.SoftDelete(recent))
+			db.Unscoped().Model(old).Update("deleted_at", time.Now().Add(-48*time.Hour))
+
+			purged, err := repo.PurgeDeleted(time.Now().Add(-24 * time.Hour))
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), purged)
+
+			var count int64
+			db.Unscoped().Model(&url.URLModel{}).Count(&count)
+			assert.Equal(t, int64(1), count)
+		})
+	})
+
+	t.Run("ListURLs", func(t *testing.T) {
+		seed := func(t *testing.T, repo url.URLRepo) {
+			t.Helper()
+			maxClicks := 1
+			for _, u := range []*url.URLModel{
+				{Original: "https://example.com/a", ShortToken: "a", Owner: "alice", Domain: "example.com", Tags: url.Tags{"blog"}, ClickCount: 5},
+				{Original: "https://docs.example.com/b", ShortToken: "b", Owner: "alice", Domain: "docs.example.com", ClickCount: 9},
+				{Original: "https://notexample.com/c", ShortToken: "c", Owner: "alice", Domain: "notexample.com", Tags: url.Tags{"blog", "launch"}, MaxClicks: &maxClicks, ClickCount: 1},
+				{Original: "https://example.com/d", ShortToken: "d", Owner: "bob", Domain: "example.com", Tags: url.Tags{"blog"}},
+			} {
+				assert.NoError(t, repo.Create(u))
+			}
+		}
+		tokens := func(urls []*url.URLModel) []string {
+			var tokens []string
+			for _, u := range urls {
+				tokens = append(tokens, u.ShortToken)
+			}
+			return tokens
+		}
+
+		t.Run("Filters by domain, tag and state", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+			seed(t, repo)
+
+			query := url.URLListQuery{Owner: "alice", Sort: url.ListByCreatedAt, Limit: 10, Now: time.Now()}
+
+			all, err := repo.ListURLs(query)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"c", "b", "a"}, tokens(all))
+
+			byDomain := query
+			byDomain.Domain = "example.com"
+			found, err := repo.ListURLs(byDomain)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"b", "a"}, tokens(found))
+
+			byTag := query
+			byTag.Tag = "launch"
+			found, err = repo.ListURLs(byTag)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"c"}, tokens(found))
+			assert.Equal(t, url.Tags{"blog", "launch"}, found[0].Tags)
+
+			active := query
+			active.State = url.ListActive
+			found, err = repo.ListURLs(active)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"b", "a"}, tokens(found))
+
+			expired := query
+			expired.State = url.ListExpired
+			found, err = repo.ListURLs(expired)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"c"}, tokens(found))
+		})
+
+		t.Run("Sorts by click count and resumes after a cursor", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+			seed(t, repo)
+
+			query := url.URLListQuery{Owner: "alice", Sort: url.ListByClickCount, Limit: 2, Now: time.Now()}
+			first, err := repo.ListURLs(query)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"b", "a"}, tokens(first))
+
+			last := first[len(first)-1]
+			query.After = &url.ListCursor{Sort: url.ListByClickCount, ClickCount: last.ClickCount, ID: last.ID}
+			rest, err := repo.ListURLs(query)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"c"}, tokens(rest))
+
+			query.After = nil
+			query.Ascending = true
+			ascending, err := repo.ListURLs(query)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"c", "a"}, tokens(ascending))
+		})
+	})
+
+	t.Run("RecordClick", func(t *testing.T) {
+		t.Run("Stores event and increments click count", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			testURL := &url.URLModel{Original: "https://github.com", ShortToken: "gh123", ClickCount: 2}
+			assert.NoError(t, repo.Create(testURL))
+
+			rowsAffected, err := repo.RecordClick(&url.ClickEvent{
+				URLID:      testURL.ID,
+				OccurredAt: time.Now(),
+				Referrer:   "https://example.com/",
+				UserAgent:  "curl/8.0",
+				IPHash:     "abc",
+			})
 			assert.NoError(t, err)
 			assert.Equal(t, int64(1), rowsAffected)
 
-			// Verify the count was incremented
 			found, err := repo.FindByShortToken("gh123")
 			assert.NoError(t, err)
-			assert.Equal(t, 11, found.ClickCount)
+			assert.Equal(t, 3, found.ClickCount)
+
+			var events []url.ClickEvent
+			assert.NoError(t, db.Where("url_id = ?", testURL.ID).Find(&events).Error)
+			assert.Len(t, events, 1)
+			assert.Equal(t, "https://example.com/", events[0].Referrer)
 		})
 
 		t.Run("Record Not Found", func(t *testing.T) {
 			db := SetupTestDB(t)
 			repo := url.NewURLRepo(db)
 
-			rowsAffected, err := repo.IncrementClickCount("nonexistent")
-			assert.Error(t, err)
-			assert.Equal(t, int64(0), rowsAffected)
+			rowsAffected, err := repo.RecordClick(&url.ClickEvent{URLID: 999, OccurredAt: time.Now()})
 			assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+			assert.Equal(t, int64(0), rowsAffected)
+
+			var count int64
+			db.Model(&url.ClickEvent{}).Count(&count)
+			assert.Equal(t, int64(0), count)
 		})
 
-		t.Run("Empty Token", func(t *testing.T) {
+		t.Run("Concurrent clicks never exceed max_clicks", func(t *testing.T) {
 			db := SetupTestDB(t)
 			repo := url.NewURLRepo(db)
 
-			rowsAffected, err := repo.IncrementClickCount("")
-			assert.Error(t, err)
-			assert.Equal(t, int64(0), rowsAffected)
-			assert.Equal(t, "short token is required", err.Error())
+			maxClicks := 5
+			testURL := &url.URLModel{Original: "https://github.com", ShortToken: "lim123", ClickCount: 4, MaxClicks: &maxClicks}
+			assert.NoError(t, repo.Create(testURL))
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 10)
+			for range 10 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := repo.RecordClick(&url.ClickEvent{URLID: testURL.ID, OccurredAt: time.Now()})
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			recorded := 0
+			for err := range errs {
+				if err == nil {
+					recorded++
+					continue
+				}
+				assert.ErrorIs(t, err, url.ErrLinkExpired)
+			}
+			assert.Equal(t, 1, recorded)
+
+			found, err := repo.FindByShortToken("lim123")
+			assert.NoError(t, err)
+			assert.Equal(t, 5, found.ClickCount)
 		})
 
-		t.Run("Multiple Increments", func(t *testing.T) {
+		t.Run("Bot clicks ignore max_clicks", func(t *testing.T) {
 			db := SetupTestDB(t)
 			repo := url.NewURLRepo(db)
 
-			// Create a URL first
-			testURL := &url.URLModel{
-				Original:   "https://stackoverflow.com",
-				ShortToken: "so123",
-				ClickCount: 0,
-			}
-			err := repo.Create(testURL)
+			maxClicks := 1
+			testURL := &url.URLModel{Original: "https://github.com", ShortToken: "bot123", ClickCount: 1, MaxClicks: &maxClicks}
+			assert.NoError(t, repo.Create(testURL))
+
+			_, err := repo.RecordClick(&url.ClickEvent{URLID: testURL.ID, OccurredAt: time.Now(), IsBot: true})
 			assert.NoError(t, err)
+		})
+	})
 
-			// Increment multiple times
-			for i := 0; i < 3; i++ {
-				rowsAffected, err := repo.IncrementClickCount("so123")
-				assert.NoError(t, err)
-				assert.Equal(t, int64(1), rowsAffected)
-			}
+	t.Run("RecordClicks", func(t *testing.T) {
+		t.Run("Stores events and adds per URL deltas", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			first := &url.URLModel{Original: "https://github.com", ShortToken: "gh123", ClickCount: 1}
+			second := &url.URLModel{Original: "https://gitlab.com", ShortToken: "gl123"}
+			assert.NoError(t, repo.Create(first))
+			assert.NoError(t, repo.Create(second))
 
-			// Verify final count
-			found, err := repo.FindByShortToken("so123")
+			now := time.Now()
+			err := repo.RecordClicks([]*url.ClickEvent{
+				{URLID: first.ID, OccurredAt: now},
+				{URLID: second.ID, OccurredAt: now},
+				{URLID: first.ID, OccurredAt: now},
+				{URLID: 999, OccurredAt: now},
+			})
 			assert.NoError(t, err)
+
+			found, _ := repo.FindByShortToken("gh123")
 			assert.Equal(t, 3, found.ClickCount)
+			found, _ = repo.FindByShortToken("gl123")
+			assert.Equal(t, 1, found.ClickCount)
+
+			var count int64
+			db.Model(&url.ClickEvent{}).Count(&count)
+			assert.Equal(t, int64(3), count)
 		})
 	})
 
+	t.Run("VisitorSketches", func(t *testing.T) {
+		t.Run("Merges human visitors per day", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			model := &url.URLModel{Original: "https://github.com", ShortToken: "gh123"}
+			assert.NoError(t, repo.Create(model))
+
+			day := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+			_, err := repo.RecordClick(&url.ClickEvent{URLID: model.ID, OccurredAt: day, Visitor: 1 << 60})
+			assert.NoError(t, err)
+			assert.NoError(t, repo.RecordClicks([]*url.ClickEvent{
+				{URLID: model.ID, OccurredAt: day.Add(time.Hour), Visitor: 1 << 60},
+				{URLID: model.ID, OccurredAt: day.Add(2 * time.Hour), Visitor: 1 << 50},
+				{URLID: model.ID, OccurredAt: day.Add(3 * time.Hour), Visitor: 1 << 40, IsBot: true},
+				{URLID: model.ID, OccurredAt: day.AddDate(0, 0, 1), Visitor: 1 << 30},
+			}))
+
+			sketches, err := repo.FindVisitorSketches(model.ID, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC))
+			assert.NoError(t, err)
+			assert.Len(t, sketches, 1)
+
+			sketch, err := hll.FromBytes(sketches[0].Sketch)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(2), sketch.Estimate())
+		})
+
+		t.Run("DailySalt is stable within a day", func(t *testing.T) {
+			db := SetupTestDB(t)
+			repo := url.NewURLRepo(db)
+
+			day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			first, err := repo.DailySalt(day)
+			assert.NoError(t, err)
+			assert.Len(t, first, 32)
+
+			again, err := repo.DailySalt(day)
+			assert.NoError(t, err)
+			assert.Equal(t, first, again)
+
+			next, err := repo.DailySalt(day.AddDate(0, 0, 1))
+			assert.NoError(t, err)
+			assert.NotEqual(t, first, next)
+		})
+	})
 }
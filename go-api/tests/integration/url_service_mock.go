@@ -1,6 +1,8 @@
 package integration
 
 import (
+	"time"
+
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 	"github.com/stretchr/testify/mock"
 )
@@ -10,14 +12,22 @@ type MockURLService struct {
 	mock.Mock
 }
 
-func (m *MockURLService) CreateShortToken(original string) (*url.URLModel, error) {
-	args := m.Called(original)
+func (m *MockURLService) CreateShortToken(params url.CreateShortTokenParams) (*url.URLModel, error) {
+	args := m.Called(params)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*url.URLModel), args.Error(1)
 }
 
+func (m *MockURLService) CreateShortTokens(params []url.CreateShortTokenParams) ([]url.BatchCreateResult, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]url.BatchCreateResult), args.Error(1)
+}
+
 func (m *MockURLService) FindByShortToken(shortToken string) (*url.URLModel, error) {
 	args := m.Called(shortToken)
 	if args.Get(0) == nil {
@@ -26,10 +36,108 @@ func (m *MockURLService) FindByShortToken(shortToken string) (*url.URLModel, err
 	return args.Get(0).(*url.URLModel), args.Error(1)
 }
 
-func (m *MockURLService) RedirectService(shortToken string) (*url.URLModel, error) {
+func (m *MockURLService) FindWithHistory(shortToken string) (*url.URLModel, error) {
+	args := m.Called(shortToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLService) UpdateDestination(params url.UpdateDestinationParams) (*url.URLModel, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLService) Delete(shortToken string, actor string) error {
+	args := m.Called(shortToken, actor)
+	return args.Error(0)
+}
+
+func (m *MockURLService) Restore(shortToken string, actor string) (*url.URLModel, error) {
+	args := m.Called(shortToken, actor)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLService) PurgeDeleted(retention time.Duration) (int64, error) {
+	args := m.Called(retention)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockURLService) RedirectService(shortToken string, click url.ClickParams) (*url.URLModel, error) {
+	args := m.Called(shortToken, click)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLService) UnlockService(shortToken string, password string, click url.ClickParams) (*url.URLModel, error) {
+	args := m.Called(shortToken, password, click)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLService) ClickTimeseries(params url.TimeseriesParams) (*url.Timeseries, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.Timeseries), args.Error(1)
+}
+
+func (m *MockURLService) ClickBreakdowns(params url.BreakdownParams) (*url.ClickBreakdowns, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.ClickBreakdowns), args.Error(1)
+}
+
+func (m *MockURLService) UniqueVisitors(params url.VisitorsParams) (*url.UniqueVisitors, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.UniqueVisitors), args.Error(1)
+}
+
+func (m *MockURLService) ExportStats(params url.ExportParams) (*url.StatsExport, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.StatsExport), args.Error(1)
+}
+
+func (m *MockURLService) ListURLs(params url.ListParams) (*url.URLPage, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLPage), args.Error(1)
+}
+
+func (m *MockURLService) ResolveService(shortToken string) (*url.URLModel, error) {
 	args := m.Called(shortToken)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*url.URLModel), args.Error(1)
 }
+
+func (m *MockURLService) PreviewService(shortToken string) (*url.Preview, error) {
+	args := m.Called(shortToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.Preview), args.Error(1)
+}
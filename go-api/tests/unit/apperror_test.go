@@ -0,0 +1,44 @@
+package unit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/apperror"
+	"github.com/nabilfikrisp/url-shortener/internal/features/url"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppError(t *testing.T) {
+	t.Run("Codes map to statuses", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, apperror.CodeInvalidRequest.Status())
+		assert.Equal(t, http.StatusUnprocessableEntity, apperror.CodeOwnDomain.Status())
+		assert.Equal(t, http.StatusNotFound, apperror.CodeNotFound.Status())
+		assert.Equal(t, http.StatusGone, apperror.CodeExpired.Status())
+		assert.Equal(t, http.StatusInternalServerError, apperror.Code("unknown").Status())
+	})
+
+	t.Run("CodeOf finds wrapped errors", func(t *testing.T) {
+		wrapped := fmt.Errorf("%w: from must be before to", url.ErrInvalidStatsQuery)
+		assert.Equal(t, apperror.CodeInvalidRequest, apperror.CodeOf(wrapped))
+		assert.Equal(t, apperror.CodeNotFound, apperror.CodeOf(url.ErrURLNotFound))
+		assert.Equal(t, apperror.CodeInternal, apperror.CodeOf(errors.New("connection refused")))
+	})
+
+	t.Run("Wrap keeps the cause", func(t *testing.T) {
+		cause := errors.New("connection refused")
+		err := apperror.Wrap(apperror.CodeInternal, "unable to save", cause)
+
+		assert.Equal(t, "unable to save: connection refused", err.Error())
+		assert.ErrorIs(t, err, cause)
+	})
+
+	t.Run("Field errors name the field", func(t *testing.T) {
+		err := apperror.Field(apperror.CodeValidation, "alias", "alias is reserved")
+
+		assert.Equal(t, "alias is reserved", err.Error())
+		assert.Equal(t, []apperror.FieldError{{Field: "alias", Message: "alias is reserved"}}, err.Fields)
+	})
+}
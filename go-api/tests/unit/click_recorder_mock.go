@@ -0,0 +1,15 @@
+package unit
+
+import (
+	"github.com/nabilfikrisp/url-shortener/internal/features/url"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockClickRecorder struct {
+	mock.Mock
+}
+
+func (m *MockClickRecorder) Record(event *url.ClickEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
@@ -0,0 +1,109 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nabilfikrisp/url-shortener/internal/features/url"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClickWriter(t *testing.T) {
+	t.Run("Flushes when the batch is full", func(t *testing.T) {
+		mockRepo := new(MockURLRepo)
+		flushed := make(chan int, 1)
+		mockRepo.On("RecordClicks", mock.Anything).Run(func(args mock.Arguments) {
+			flushed <- len(args.Get(0).([]*url.ClickEvent))
+		}).Return(nil)
+
+		writer := url.NewClickWriter(mockRepo, url.ClickWriterParams{BatchSize: 2, FlushInterval: time.Hour})
+		defer writer.Close()
+
+		writer.Record(&url.ClickEvent{URLID: 1})
+		writer.Record(&url.ClickEvent{URLID: 2})
+
+		select {
+		case n := <-flushed:
+			assert.Equal(t, 2, n)
+		case <-time.After(time.Second):
+			t.Fatal("batch was not flushed")
+		}
+	})
+
+	t.Run("Flushes on the interval", func(t *testing.T) {
+		mockRepo := new(MockURLRepo)
+		flushed := make(chan int, 1)
+		mockRepo.On("RecordClicks", mock.Anything).Run(func(args mock.Arguments) {
+			flushed <- len(args.Get(0).([]*url.ClickEvent))
+		}).Return(nil)
+
+		writer := url.NewClickWriter(mockRepo, url.ClickWriterParams{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+		defer writer.Close()
+
+		writer.Record(&url.ClickEvent{URLID: 1})
+
+		select {
+		case n := <-flushed:
+			assert.Equal(t, 1, n)
+		case <-time.After(time.Second):
+			t.Fatal("batch was not flushed")
+		}
+	})
+
+	t.Run("Close drains queued clicks", func(t *testing.T) {
+		mockRepo := new(MockURLRepo)
+		total := 0
+		mockRepo.On("RecordClicks", mock.Anything).Run(func(args mock.Arguments) {
+			total += len(args.Get(0).([]*url.ClickEvent))
+		}).Return(nil)
+
+		writer := url.NewClickWriter(mockRepo, url.ClickWriterParams{BatchSize: 100, FlushInterval: time.Hour})
+		for i := range 5 {
+			writer.Record(&url.ClickEvent{URLID: uint(i)})
+		}
+		writer.Close()
+
+		assert.Equal(t, 5, total)
+		assert.Equal(t, uint64(0), writer.Dropped())
+	})
+
+	t.Run("Counts clicks dropped while the queue is full", func(t *testing.T) {
+		mockRepo := new(MockURLRepo)
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		mockRepo.On("RecordClicks", mock.Anything).Run(func(args mock.Arguments) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+		}).Return(nil)
+
+		writer := url.NewClickWriter(mockRepo, url.ClickWriterParams{QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour})
+
+		// the first click is taken off the queue and blocks in the flush
+		writer.Record(&url.ClickEvent{URLID: 1})
+		<-started
+
+		writer.Record(&url.ClickEvent{URLID: 2})
+		writer.Record(&url.ClickEvent{URLID: 3})
+
+		close(release)
+		writer.Close()
+
+		assert.Equal(t, uint64(1), writer.Dropped())
+	})
+
+	t.Run("Counts clicks lost in a failed flush", func(t *testing.T) {
+		mockRepo := new(MockURLRepo)
+		mockRepo.On("RecordClicks", mock.Anything).Return(assert.AnError)
+
+		writer := url.NewClickWriter(mockRepo, url.ClickWriterParams{BatchSize: 100, FlushInterval: time.Hour})
+		writer.Record(&url.ClickEvent{URLID: 1})
+		writer.Record(&url.ClickEvent{URLID: 2})
+		writer.Close()
+
+		assert.Equal(t, uint64(2), writer.Dropped())
+	})
+}
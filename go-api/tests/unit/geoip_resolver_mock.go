@@ -0,0 +1,20 @@
+package unit
+
+import (
+	"github.com/nabilfikrisp/url-shortener/internal/common/geoip"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockGeoResolver struct {
+	mock.Mock
+}
+
+func (m *MockGeoResolver) Lookup(ip string) geoip.Location {
+	args := m.Called(ip)
+	return args.Get(0).(geoip.Location)
+}
+
+func (m *MockGeoResolver) Close() error {
+	args := m.Called()
+	return args.Error(0)
+}
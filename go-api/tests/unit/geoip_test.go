@@ -0,0 +1,26 @@
+package unit
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/geoip"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeoIP(t *testing.T) {
+	t.Run("Empty path disables lookups", func(t *testing.T) {
+		resolver, err := geoip.Open("")
+
+		assert.NoError(t, err)
+		assert.Equal(t, geoip.Location{}, resolver.Lookup("8.8.8.8"))
+		assert.NoError(t, resolver.Close())
+	})
+
+	t.Run("Missing database is an error", func(t *testing.T) {
+		resolver, err := geoip.Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+
+		assert.Error(t, err)
+		assert.Nil(t, resolver)
+	})
+}
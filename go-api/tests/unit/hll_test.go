@@ -0,0 +1,77 @@
+package unit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/hll"
+	"github.com/stretchr/testify/assert"
+)
+
+func hashOf(i int) uint64 {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("visitor-%d", i)))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func sketchOf(from, to int) *hll.Sketch {
+	sketch := hll.New()
+	for i := from; i < to; i++ {
+		sketch.Add(hashOf(i))
+	}
+	return sketch
+}
+
+func assertWithin(t *testing.T, want, got uint64, tolerance float64) {
+	t.Helper()
+	diff := math.Abs(float64(got)-float64(want)) / float64(want)
+	assert.LessOrEqual(t, diff, tolerance, "estimate %d too far from %d", got, want)
+}
+
+func TestHyperLogLog(t *testing.T) {
+	t.Run("Empty sketch estimates zero", func(t *testing.T) {
+		assert.Equal(t, uint64(0), hll.New().Estimate())
+	})
+
+	t.Run("Counts small sets almost exactly", func(t *testing.T) {
+		assertWithin(t, 100, sketchOf(0, 100).Estimate(), 0.02)
+	})
+
+	t.Run("Ignores repeated elements", func(t *testing.T) {
+		sketch := sketchOf(0, 500)
+		for i := 0; i < 500; i++ {
+			sketch.Add(hashOf(i))
+		}
+		assertWithin(t, 500, sketch.Estimate(), 0.03)
+	})
+
+	t.Run("Estimates large sets within a few percent", func(t *testing.T) {
+		assertWithin(t, 100000, sketchOf(0, 100000).Estimate(), 0.05)
+	})
+
+	t.Run("Merge counts the union of overlapping sets", func(t *testing.T) {
+		sketch := sketchOf(0, 6000)
+		sketch.Merge(sketchOf(4000, 10000))
+
+		assertWithin(t, 10000, sketch.Estimate(), 0.05)
+	})
+
+	t.Run("Round-trips through its binary form", func(t *testing.T) {
+		sketch := sketchOf(0, 2000)
+
+		data, err := sketch.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Len(t, data, 1<<hll.Precision)
+
+		decoded, err := hll.FromBytes(data)
+		assert.NoError(t, err)
+		assert.Equal(t, sketch.Estimate(), decoded.Estimate())
+	})
+
+	t.Run("Rejects data of the wrong size", func(t *testing.T) {
+		_, err := hll.FromBytes([]byte{1, 2, 3})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,116 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	t.Run("lowercases scheme and host and drops default port", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("HTTP://Example.COM:80/Path", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "http://example.com/Path", got)
+
+		got, err = helpers.NormalizeURL("https://example.com:443/", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/", got)
+	})
+
+	t.Run("keeps non-default port", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("http://example.com:8080/a", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "http://example.com:8080/a", got)
+	})
+
+	t.Run("sorts query parameters", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("https://example.com/a?b=2&a=1&c=3", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/a?a=1&b=2&c=3", got)
+	})
+
+	t.Run("strips configured tracking parameters", func(t *testing.T) {
+		opts := helpers.NormalizeURLOptions{StripParams: []string{"utm_*", "fbclid", "gclid"}}
+
+		got, err := helpers.NormalizeURL("https://example.com/a?utm_source=x&UTM_Medium=y&fbclid=1&id=7&gclid=2", opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/a?id=7", got)
+
+		got, err = helpers.NormalizeURL("https://example.com/a?utm_source=x", opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/a", got)
+	})
+
+	t.Run("keeps trailing slash by default", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("https://example.com/a/", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/a/", got)
+	})
+
+	t.Run("equivalent URLs normalize the same with TrimTrailingSlash", func(t *testing.T) {
+		opts := helpers.NormalizeURLOptions{TrimTrailingSlash: true}
+
+		got1, err := helpers.NormalizeURL("HTTP://Example.com:80/a/", opts)
+		assert.NoError(t, err)
+		got2, err := helpers.NormalizeURL("http://example.com/a", opts)
+		assert.NoError(t, err)
+
+		assert.Equal(t, "http://example.com/a", got1)
+		assert.Equal(t, got1, got2)
+	})
+
+	t.Run("keeps fragment", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("https://example.com/a#section", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/a#section", got)
+	})
+
+	t.Run("keeps query pairs it cannot decode", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("https://example.com/p?z=1&a=1;b=2", helpers.NormalizeURLOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/p?a=1;b=2&z=1", got)
+
+		got, err = helpers.NormalizeURL("https://example.com/?q=100%&utm_source=x", helpers.NormalizeURLOptions{StripParams: []string{"utm_*"}})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/?q=100%", got)
+	})
+
+	t.Run("empty path becomes /", func(t *testing.T) {
+		for _, opts := range []helpers.NormalizeURLOptions{{}, {TrimTrailingSlash: true}} {
+			got1, err := helpers.NormalizeURL("http://example.com", opts)
+			assert.NoError(t, err)
+			got2, err := helpers.NormalizeURL("http://example.com/", opts)
+			assert.NoError(t, err)
+
+			assert.Equal(t, "http://example.com/", got1)
+			assert.Equal(t, got1, got2)
+		}
+	})
+
+	t.Run("URL without hostname", func(t *testing.T) {
+		got, err := helpers.NormalizeURL("http://", helpers.NormalizeURLOptions{})
+		assert.Error(t, err)
+		assert.Empty(t, got)
+	})
+}
+
+func TestSetQueryParams(t *testing.T) {
+	t.Run("adds parameters and keeps existing ones", func(t *testing.T) {
+		got, err := helpers.SetQueryParams("https://example.com/a?id=7#top", map[string]string{"utm_source": "mail", "utm_term": ""})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/a?id=7&utm_source=mail#top", got)
+	})
+
+	t.Run("replaces parameters of the same name", func(t *testing.T) {
+		got, err := helpers.SetQueryParams("https://example.com/?utm_source=a&utm_source=b", map[string]string{"utm_source": "c"})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/?utm_source=c", got)
+	})
+
+	t.Run("escapes values", func(t *testing.T) {
+		got, err := helpers.SetQueryParams("https://example.com/", map[string]string{"utm_campaign": "50% off & more"})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/?utm_campaign=50%25+off+%26+more", got)
+	})
+}
@@ -0,0 +1,35 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/referrer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferrerSource(t *testing.T) {
+	cases := []struct {
+		name    string
+		referer string
+		want    string
+	}{
+		{"empty header is direct", "", referrer.Direct},
+		{"groups google.com", "https://www.google.com/search?q=x", "google"},
+		{"groups other Google TLDs", "https://www.google.co.uk/", "google"},
+		{"groups Google TLD with country subdomain", "https://google.co.id/url?q=x", "google"},
+		{"groups shortener domains", "https://t.co/abc", "x"},
+		{"groups mobile subdomains by label", "https://m.facebook.com/", "facebook"},
+		{"keeps specific host groups", "https://mail.google.com/mail/u/0/", "gmail"},
+		{"reduces unknown hosts to the registrable domain", "https://blog.example.co.uk/post/1", "example.co.uk"},
+		{"ignores case and port", "HTTPS://News.Example.COM:8443/", "example.com"},
+		{"keeps IP addresses", "http://203.0.113.9/page", "203.0.113.9"},
+		{"uses the app id of app referrers", "android-app://com.slack/", "com.slack"},
+		{"unparseable referer is unknown", "not a url", referrer.Unknown},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, referrer.Source(tc.referer))
+		})
+	}
+}
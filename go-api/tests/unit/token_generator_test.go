@@ -0,0 +1,150 @@
+package unit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/stretchr/testify/assert"
+)
+
+func sequenceFrom(start uint64) helpers.SequenceFunc {
+	n := start
+	return func() (uint64, error) {
+		n++
+		return n, nil
+	}
+}
+
+func TestTokenGenerator(t *testing.T) {
+	t.Run("Hash", func(t *testing.T) {
+		t.Run("matches GenerateShortToken at 16 characters", func(t *testing.T) {
+			gen := helpers.NewHashTokenGenerator(16)
+
+			token, err := gen.Generate("https://test.com", 0)
+
+			assert.NoError(t, err)
+			assert.Equal(t, helpers.GenerateShortToken("https://test.com"), token)
+		})
+
+		t.Run("retries give different tokens for the same input", func(t *testing.T) {
+			gen := helpers.NewHashTokenGenerator(16)
+
+			token0, _ := gen.Generate("https://test.com", 0)
+			token1, _ := gen.Generate("https://test.com", 1)
+			token1Again, _ := gen.Generate("https://test.com", 1)
+
+			assert.NotEqual(t, token0, token1)
+			assert.Equal(t, token1, token1Again)
+		})
+
+		t.Run("retries past the hash attempts are random", func(t *testing.T) {
+			gen := helpers.NewHashTokenGenerator(16)
+
+			token, _ := gen.Generate("https://test.com", helpers.HashAttempts)
+			tokenAgain, _ := gen.Generate("https://test.com", helpers.HashAttempts)
+
+			assert.Len(t, token, 16)
+			assert.NotEqual(t, token, tokenAgain)
+		})
+
+		t.Run("respects configured length", func(t *testing.T) {
+			gen := helpers.NewHashTokenGenerator(8)
+
+			token, err := gen.Generate("https://test.com", 0)
+
+			assert.NoError(t, err)
+			assert.Len(t, token, 8)
+		})
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		t.Run("returns base62 tokens of configured length", func(t *testing.T) {
+			gen := helpers.NewRandomTokenGenerator(7)
+
+			for range 20 {
+				token, err := gen.Generate("https://test.com", 0)
+
+				assert.NoError(t, err)
+				assert.Len(t, token, 7)
+				assert.Regexp(t, "^[0-9A-Za-z]+$", token)
+			}
+		})
+
+		t.Run("same input gives different outputs", func(t *testing.T) {
+			gen := helpers.NewRandomTokenGenerator(12)
+
+			token1, _ := gen.Generate("https://test.com", 0)
+			token2, _ := gen.Generate("https://test.com", 0)
+
+			assert.NotEqual(t, token1, token2)
+		})
+	})
+
+	t.Run("Sequence", func(t *testing.T) {
+		t.Run("encodes consecutive values in base62", func(t *testing.T) {
+			gen := helpers.NewSequenceTokenGenerator(sequenceFrom(60), 0)
+
+			token1, _ := gen.Generate("", 0)
+			token2, _ := gen.Generate("", 0)
+			token3, _ := gen.Generate("", 0)
+
+			assert.Equal(t, []string{"z", "10", "11"}, []string{token1, token2, token3})
+		})
+
+		t.Run("pads to minimum length", func(t *testing.T) {
+			gen := helpers.NewSequenceTokenGenerator(sequenceFrom(0), 6)
+
+			token, err := gen.Generate("", 0)
+
+			assert.NoError(t, err)
+			assert.Equal(t, "000001", token)
+		})
+
+		t.Run("returns error if sequence fails", func(t *testing.T) {
+			gen := helpers.NewSequenceTokenGenerator(func() (uint64, error) {
+				return 0, errors.New("db error")
+			}, 0)
+
+			token, err := gen.Generate("", 0)
+
+			assert.Error(t, err)
+			assert.Empty(t, token)
+		})
+	})
+
+	t.Run("Hashids", func(t *testing.T) {
+		t.Run("returns unique tokens of minimum length", func(t *testing.T) {
+			gen := helpers.NewHashidsTokenGenerator(sequenceFrom(0), "pepper", 6)
+
+			seen := map[string]bool{}
+			for range 1000 {
+				token, err := gen.Generate("", 0)
+
+				assert.NoError(t, err)
+				assert.Len(t, token, 6)
+				assert.False(t, seen[token], "token %s repeated", token)
+				seen[token] = true
+			}
+		})
+
+		t.Run("different salts give different tokens", func(t *testing.T) {
+			gen1 := helpers.NewHashidsTokenGenerator(sequenceFrom(0), "salt-one", 6)
+			gen2 := helpers.NewHashidsTokenGenerator(sequenceFrom(0), "salt-two", 6)
+
+			token1, _ := gen1.Generate("", 0)
+			token2, _ := gen2.Generate("", 0)
+
+			assert.NotEqual(t, token1, token2)
+		})
+
+		t.Run("consecutive values do not look consecutive", func(t *testing.T) {
+			gen := helpers.NewHashidsTokenGenerator(sequenceFrom(0), "pepper", 6)
+
+			token1, _ := gen.Generate("", 0)
+			token2, _ := gen.Generate("", 0)
+
+			assert.NotEqual(t, token1[:5], token2[:5])
+		})
+	})
+}
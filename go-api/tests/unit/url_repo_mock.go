@@ -1,6 +1,8 @@
 package unit
 
 import (
+	"time"
+
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 	"github.com/stretchr/testify/mock"
 )
@@ -14,6 +16,11 @@ func (m *MockURLRepo) Create(u *url.URLModel) error {
 	return args.Error(0)
 }
 
+func (m *MockURLRepo) CreateBatch(urls []*url.URLModel) error {
+	args := m.Called(urls)
+	return args.Error(0)
+}
+
 func (m *MockURLRepo) FindByShortToken(token string) (*url.URLModel, error) {
 	args := m.Called(token)
 	if args.Get(0) == nil {
@@ -22,7 +29,132 @@ func (m *MockURLRepo) FindByShortToken(token string) (*url.URLModel, error) {
 	return args.Get(0).(*url.URLModel), args.Error(1)
 }
 
-func (m *MockURLRepo) IncrementClickCount(token string) (int64, error) {
+func (m *MockURLRepo) FindByShortTokens(tokens []string) ([]*url.URLModel, error) {
+	args := m.Called(tokens)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLRepo) FindByShortTokenWithHistory(token string) (*url.URLModel, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLRepo) ListURLs(query url.URLListQuery) ([]*url.URLModel, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLRepo) UpdateDestination(u *url.URLModel, history *url.URLDestinationHistory) error {
+	args := m.Called(u, history)
+	return args.Error(0)
+}
+
+func (m *MockURLRepo) SoftDelete(u *url.URLModel) error {
+	args := m.Called(u)
+	return args.Error(0)
+}
+
+func (m *MockURLRepo) FindDeletedByShortToken(token string) (*url.URLModel, error) {
 	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*url.URLModel), args.Error(1)
+}
+
+func (m *MockURLRepo) Restore(u *url.URLModel) error {
+	args := m.Called(u)
+	return args.Error(0)
+}
+
+func (m *MockURLRepo) PurgeDeleted(before time.Time) (int64, error) {
+	args := m.Called(before)
 	return args.Get(0).(int64), args.Error(1)
 }
+
+func (m *MockURLRepo) RecordClick(event *url.ClickEvent) (int64, error) {
+	args := m.Called(event)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockURLRepo) RecordClicks(events []*url.ClickEvent) error {
+	args := m.Called(events)
+	return args.Error(0)
+}
+
+func (m *MockURLRepo) CountClicksByBucket(params url.ClickBucketParams) ([]url.ClickBucketCount, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]url.ClickBucketCount), args.Error(1)
+}
+
+func (m *MockURLRepo) CountClicks(params url.ClickRangeParams) (int64, error) {
+	args := m.Called(params)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockURLRepo) TopClickValues(params url.ClickTopParams) ([]url.BreakdownItem, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]url.BreakdownItem), args.Error(1)
+}
+
+func (m *MockURLRepo) FindVisitorSketches(urlID uint, from time.Time, to time.Time) ([]url.VisitorSketch, error) {
+	args := m.Called(urlID, from, to)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]url.VisitorSketch), args.Error(1)
+}
+
+func (m *MockURLRepo) DailySalt(day time.Time) ([]byte, error) {
+	args := m.Called(day)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+// EachClickEvent calls fn with each click given to Return.
+func (m *MockURLRepo) EachClickEvent(params url.ClickExportParams, fn func(url.ExportedClick) error) error {
+	args := m.Called(params)
+	if clicks, ok := args.Get(0).([]url.ExportedClick); ok {
+		for _, click := range clicks {
+			if err := fn(click); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+// EachDailyClicks calls fn with each count given to Return.
+func (m *MockURLRepo) EachDailyClicks(params url.ClickExportParams, fn func(url.DailyClickCount) error) error {
+	args := m.Called(params)
+	if counts, ok := args.Get(0).([]url.DailyClickCount); ok {
+		for _, count := range counts {
+			if err := fn(count); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockURLRepo) NextSequence() (uint64, error) {
+	args := m.Called()
+	return args.Get(0).(uint64), args.Error(1)
+}
@@ -3,25 +3,29 @@ package unit
 import (
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/nabilfikrisp/url-shortener/internal/common/geoip"
 	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
 	"github.com/nabilfikrisp/url-shortener/internal/features/url"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestURLService(t *testing.T) {
 	t.Run("CreateShortToken", func(t *testing.T) {
 		t.Run("Returns existing URL if token already exists", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
-			token := helpers.GenerateShortToken("https://exists.com")
-			existing := &url.URLModel{Original: "https://exists.com", ShortToken: token}
+			token := helpers.GenerateShortToken("https://exists.com/")
+			existing := &url.URLModel{Original: "https://exists.com/", ShortToken: token}
 
 			mockRepo.On("FindByShortToken", token).Return(existing, nil)
 
-			result, err := service.CreateShortToken("https://exists.com")
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://exists.com/"})
 
 			assert.NoError(t, err)
 			assert.Equal(t, existing, result)
@@ -30,30 +34,402 @@ func TestURLService(t *testing.T) {
 
 		t.Run("Success if token does not exist", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
-			token := helpers.GenerateShortToken("https://new.com")
+			token := helpers.GenerateShortToken("https://new.com/")
 
 			mockRepo.On("FindByShortToken", token).Return(nil, nil)
 			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
 
-			result, err := service.CreateShortToken("https://new.com")
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/"})
 
 			assert.NoError(t, err)
-			assert.Equal(t, "https://new.com", result.Original)
+			assert.Equal(t, "https://new.com/", result.Original)
 			assert.Equal(t, token, result.ShortToken)
 			mockRepo.AssertExpectations(t)
 		})
 
+		t.Run("Retries with salted token on collision with different URL", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			collided := &url.URLModel{Original: "https://someone-else.com/", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(collided, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "https://new.com/", result.Original)
+			assert.Equal(t, retryToken, result.ShortToken)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns existing URL stored under a retry token", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			collided := &url.URLModel{Original: "https://someone-else.com/", ShortToken: token}
+			existing := &url.URLModel{Original: "https://new.com/", ShortToken: retryToken}
+
+			mockRepo.On("FindByShortToken", token).Return(collided, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(existing, nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
+			mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns error when every attempt collides", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			collided := &url.URLModel{Original: "https://someone-else.com/"}
+
+			mockRepo.On("FindByShortToken", mock.AnythingOfType("string")).Return(collided, nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/"})
+
+			assert.Error(t, err)
+			assert.Equal(t, "unable to generate a unique short token", err.Error())
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+		})
+
+		t.Run("Falls back to random tokens once the hash attempts are taken", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			// the hash attempts hold links to the same URL expiring at other times
+			taken := map[string]bool{}
+			for attempt := range helpers.HashAttempts {
+				token, _ := generator.Generate("https://promo.com/", attempt)
+				expiresAt := time.Now().Add(time.Duration(attempt+1) * time.Hour)
+				taken[token] = true
+				mockRepo.On("FindByShortToken", token).Return(&url.URLModel{Original: "https://promo.com/", ShortToken: token, ExpiresAt: &expiresAt}, nil)
+			}
+			mockRepo.On("FindByShortToken", mock.AnythingOfType("string")).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			expiresAt := time.Now().Add(24 * time.Hour)
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://promo.com/", ExpiresAt: &expiresAt})
+
+			assert.NoError(t, err)
+			assert.NotContains(t, taken, result.ShortToken)
+			assert.Equal(t, &expiresAt, result.ExpiresAt)
+			mockRepo.AssertCalled(t, "Create", result)
+		})
+
+		t.Run("Uses alias as token when provided", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "spring-sale").Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", Alias: "spring-sale"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "spring-sale", result.ShortToken)
+			assert.Equal(t, "https://new.com/", result.Original)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrAliasTaken if alias points elsewhere", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://someone-else.com/", ShortToken: "spring-sale"}
+			mockRepo.On("FindByShortToken", "spring-sale").Return(existing, nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", Alias: "spring-sale"})
+
+			assert.ErrorIs(t, err, url.ErrAliasTaken)
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+		})
+
+		t.Run("Returns existing URL if alias already points to it", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://new.com/", ShortToken: "spring-sale"}
+			mockRepo.On("FindByShortToken", "spring-sale").Return(existing, nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", Alias: "spring-sale"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
+			mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+		})
+
+		t.Run("Creates a new link when limits differ from existing one", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			maxClicks := 10
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			unlimited := &url.URLModel{Original: "https://new.com/", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(unlimited, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", MaxClicks: &maxClicks})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+			assert.Equal(t, &maxClicks, result.MaxClicks)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Creates a new link when the matching one is used up", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			maxClicks := 1
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			usedUp := &url.URLModel{Original: "https://new.com/", ShortToken: token, ClickCount: 1, MaxClicks: &maxClicks}
+
+			mockRepo.On("FindByShortToken", token).Return(usedUp, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", MaxClicks: &maxClicks})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+			assert.Zero(t, result.ClickCount)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Creates a new link when the matching one has expired", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			expiresAt := time.Now().Add(-time.Minute)
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			expired := &url.URLModel{Original: "https://new.com/", ShortToken: token, ExpiresAt: &expiresAt}
+
+			mockRepo.On("FindByShortTokens", []string{token}).Return([]*url.URLModel{expired}, nil)
+			mockRepo.On("FindByShortTokens", []string{retryToken}).Return([]*url.URLModel{}, nil)
+			mockRepo.On("CreateBatch", mock.Anything).Return(nil)
+
+			results, err := service.CreateShortTokens([]url.CreateShortTokenParams{{Original: "https://new.com/", ExpiresAt: &expiresAt}})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, results[0].URL.ShortToken)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Creates a new link when the redirect type differs from existing one", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			temporary := &url.URLModel{Original: "https://new.com/", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(temporary, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", RedirectType: 301})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+			assert.Equal(t, 301, result.RedirectType)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Stores normalized URL and keeps raw input", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{StripQueryParams: []string{"utm_*"}})
+
+			token, _ := generator.Generate("https://new.com/a?id=1", 0)
+
+			mockRepo.On("FindByShortToken", token).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "HTTPS://New.com:443/a?utm_source=mail&id=1"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "https://new.com/a?id=1", result.Original)
+			assert.Equal(t, "HTTPS://New.com:443/a?utm_source=mail&id=1", result.RawOriginal)
+			assert.Equal(t, token, result.ShortToken)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Merges UTM fields into the destination", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{StripQueryParams: []string{"utm_*"}})
+
+			want := "https://new.com/a?id=1&utm_campaign=spring+sale&utm_medium=email&utm_source=newsletter"
+			token, _ := generator.Generate(want, 0)
+
+			mockRepo.On("FindByShortToken", token).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{
+				Original: "https://new.com/a?id=1&utm_source=typo",
+				UTM:      url.UTM{Source: " newsletter ", Medium: "email", Campaign: "spring sale"},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, want, result.Original)
+			assert.Equal(t, url.UTM{Source: "newsletter", Medium: "email", Campaign: "spring sale"}, result.UTM)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Creates a new link when UTM fields differ from existing one", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			// same destination, but built by hand instead of with UTM fields
+			original := "https://new.com/?utm_campaign=launch"
+			token, _ := generator.Generate(original, 0)
+			retryToken, _ := generator.Generate(original, 1)
+			existing := &url.URLModel{Original: original, ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{
+				Original: "https://new.com/",
+				UTM:      url.UTM{Campaign: "launch"},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+			assert.Equal(t, "launch", result.UTM.Campaign)
+		})
+
+		t.Run("Stores normalized tags", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", mock.Anything).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{
+				Original: "https://blog.example.com/post",
+				Tags:     []string{" Launch", "blog", "launch"},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, url.Tags{"blog", "launch"}, result.Tags)
+			assert.Equal(t, "blog.example.com", result.Domain)
+		})
+
+		t.Run("Creates a new link when tags differ from existing one", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			existing := &url.URLModel{Original: "https://new.com/", ShortToken: token, Tags: url.Tags{"docs"}}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{
+				Original: "https://new.com/",
+				Tags:     []string{"launch"},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+		})
+
+		t.Run("Retries when token is held by a soft-deleted URL", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+
+			mockRepo.On("FindByShortToken", token).Return(nil, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.MatchedBy(func(u *url.URLModel) bool { return u.ShortToken == token })).Return(url.ErrShortTokenTaken)
+			mockRepo.On("Create", mock.MatchedBy(func(u *url.URLModel) bool { return u.ShortToken == retryToken })).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrAliasTaken if alias is held by a soft-deleted URL", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "spring-sale").Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(url.ErrShortTokenTaken)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", Alias: "spring-sale"})
+
+			assert.ErrorIs(t, err, url.ErrAliasTaken)
+			assert.Nil(t, result)
+		})
+
+		t.Run("Hashes password and never reuses an existing link", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://new.com/", 0)
+			retryToken, _ := generator.Generate("https://new.com/", 1)
+			public := &url.URLModel{Original: "https://new.com/", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(public, nil)
+			mockRepo.On("FindByShortToken", retryToken).Return(nil, nil)
+			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(nil)
+
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://new.com/", Password: "s3cret"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, result.ShortToken)
+			assert.True(t, result.IsProtected())
+			assert.NotEqual(t, "s3cret", result.PasswordHash)
+			mockRepo.AssertExpectations(t)
+		})
+
 		t.Run("Returns error if repo.FindByShortToken fails", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
-			token := helpers.GenerateShortToken("https://error.com")
+			token := helpers.GenerateShortToken("https://error.com/")
 
 			mockRepo.On("FindByShortToken", token).Return(nil, errors.New("db error"))
 
-			result, err := service.CreateShortToken("https://error.com")
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://error.com/"})
 
 			assert.Error(t, err)
 			assert.Nil(t, result)
@@ -62,14 +438,14 @@ func TestURLService(t *testing.T) {
 
 		t.Run("Returns error if repo.Create fails", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
-			token := helpers.GenerateShortToken("https://fail.com")
+			token := helpers.GenerateShortToken("https://fail.com/")
 
 			mockRepo.On("FindByShortToken", token).Return(nil, nil)
 			mockRepo.On("Create", mock.AnythingOfType("*url.URLModel")).Return(errors.New("insert failed"))
 
-			result, err := service.CreateShortToken("https://fail.com")
+			result, err := service.CreateShortToken(url.CreateShortTokenParams{Original: "https://fail.com/"})
 
 			assert.Error(t, err)
 			assert.Nil(t, result)
@@ -77,10 +453,91 @@ func TestURLService(t *testing.T) {
 		})
 	})
 
+	t.Run("CreateShortTokens", func(t *testing.T) {
+		t.Run("Returns results in input order and inserts only new URLs", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			tokenA, _ := generator.Generate("https://a.com/", 0)
+			tokenB, _ := generator.Generate("https://b.com/", 0)
+			existingB := &url.URLModel{Original: "https://b.com/", ShortToken: tokenB}
+
+			mockRepo.On("FindByShortTokens", []string{tokenA, tokenB, tokenA}).Return([]*url.URLModel{existingB}, nil)
+			mockRepo.On("CreateBatch", mock.MatchedBy(func(urls []*url.URLModel) bool {
+				return len(urls) == 1 && urls[0].ShortToken == tokenA
+			})).Return(nil)
+
+			results, err := service.CreateShortTokens([]url.CreateShortTokenParams{
+				{Original: "https://a.com/"},
+				{Original: "https://b.com/"},
+				{Original: "https://a.com/"},
+			})
+
+			assert.NoError(t, err)
+			assert.Len(t, results, 3)
+			assert.Equal(t, tokenA, results[0].URL.ShortToken)
+			assert.Equal(t, existingB, results[1].URL)
+			assert.Same(t, results[0].URL, results[2].URL)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Retries collided tokens in a second lookup", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			generator := helpers.NewHashTokenGenerator(16)
+			service := url.NewURLService(mockRepo, generator, nil, nil, &config.Config{})
+
+			token, _ := generator.Generate("https://a.com/", 0)
+			retryToken, _ := generator.Generate("https://a.com/", 1)
+			collided := &url.URLModel{Original: "https://someone-else.com/", ShortToken: token}
+
+			mockRepo.On("FindByShortTokens", []string{token}).Return([]*url.URLModel{collided}, nil)
+			mockRepo.On("FindByShortTokens", []string{retryToken}).Return([]*url.URLModel{}, nil)
+			mockRepo.On("CreateBatch", mock.Anything).Return(nil)
+
+			results, err := service.CreateShortTokens([]url.CreateShortTokenParams{{Original: "https://a.com/"}})
+
+			assert.NoError(t, err)
+			assert.Equal(t, retryToken, results[0].URL.ShortToken)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Reports taken alias per item", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortTokens", []string{"promo", "promo"}).Return([]*url.URLModel{}, nil)
+			mockRepo.On("CreateBatch", mock.Anything).Return(nil)
+
+			results, err := service.CreateShortTokens([]url.CreateShortTokenParams{
+				{Original: "https://a.com/", Alias: "promo"},
+				{Original: "https://b.com/", Alias: "promo"},
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "promo", results[0].URL.ShortToken)
+			assert.Nil(t, results[1].URL)
+			assert.ErrorIs(t, results[1].Err, url.ErrAliasTaken)
+		})
+
+		t.Run("Returns error if repo.CreateBatch fails", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortTokens", mock.Anything).Return([]*url.URLModel{}, nil)
+			mockRepo.On("CreateBatch", mock.Anything).Return(errors.New("insert failed"))
+
+			results, err := service.CreateShortTokens([]url.CreateShortTokenParams{{Original: "https://a.com/"}})
+
+			assert.Error(t, err)
+			assert.Nil(t, results)
+		})
+	})
+
 	t.Run("FindByShortToken", func(t *testing.T) {
 		t.Run("Success when URL exists", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
 			token := "abc123"
 			existing := &url.URLModel{Original: "https://example.com", ShortToken: token}
@@ -96,7 +553,7 @@ func TestURLService(t *testing.T) {
 
 		t.Run("Returns error when URL not found", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
 			token := "notfound"
 
@@ -112,7 +569,7 @@ func TestURLService(t *testing.T) {
 
 		t.Run("Returns error when repo fails", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
 			token := "error"
 
@@ -127,49 +584,710 @@ func TestURLService(t *testing.T) {
 		})
 	})
 
-	t.Run("RedirectService", func(t *testing.T) {
-		t.Run("Success when URL exists and click count increments", func(t *testing.T) {
+	t.Run("UpdateDestination", func(t *testing.T) {
+		t.Run("Records previous destination in history", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
-			token := "abc123"
-			existing := &url.URLModel{Original: "https://example.com", ShortToken: token}
+			existing := &url.URLModel{ID: 7, Original: "https://old.com/", ShortToken: "abc123", Owner: "alice"}
 
-			mockRepo.On("FindByShortToken", token).Return(existing, nil)
-			mockRepo.On("IncrementClickCount", token).Return(int64(1), nil)
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("UpdateDestination", existing, mock.MatchedBy(func(h *url.URLDestinationHistory) bool {
+				return h.URLID == 7 && h.Original == "https://old.com/" && h.ChangedBy == "alice"
+			})).Return(nil)
 
-			result, err := service.RedirectService(token)
+			result, err := service.UpdateDestination(url.UpdateDestinationParams{ShortToken: "abc123", Original: "https://new.com/", Actor: "alice"})
 
 			assert.NoError(t, err)
-			assert.Equal(t, existing, result)
+			assert.Equal(t, "https://new.com/", result.Original)
 			mockRepo.AssertExpectations(t)
 		})
 
-		t.Run("Returns error when URL not found", func(t *testing.T) {
+		t.Run("Merges the link's UTM fields into the new destination", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
-			token := "notfound"
+			utm := url.UTM{Source: "newsletter", Campaign: "spring"}
+			existing := &url.URLModel{ID: 7, Original: "https://old.com/?utm_campaign=spring&utm_source=newsletter", ShortToken: "abc123", Owner: "alice", UTM: utm}
 
-			mockRepo.On("FindByShortToken", token).Return(nil, nil)
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("UpdateDestination", existing, mock.AnythingOfType("*url.URLDestinationHistory")).Return(nil)
 
-			result, err := service.RedirectService(token)
+			result, err := service.UpdateDestination(url.UpdateDestinationParams{ShortToken: "abc123", Original: "https://new.com/?utm_source=ads&ref=x", Actor: "alice"})
 
-			assert.Error(t, err)
-			assert.Equal(t, "short URL not found", err.Error())
+			assert.NoError(t, err)
+			assert.Equal(t, "https://new.com/?ref=x&utm_campaign=spring&utm_source=newsletter", result.Original)
+			assert.Equal(t, "https://new.com/?utm_source=ads&ref=x", result.RawOriginal)
+			assert.Equal(t, utm, result.UTM)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrNotOwner for another caller", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://old.com/", ShortToken: "abc123", Owner: "alice"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+
+			result, err := service.UpdateDestination(url.UpdateDestinationParams{ShortToken: "abc123", Original: "https://new.com/", Actor: "bob"})
+
+			assert.ErrorIs(t, err, url.ErrNotOwner)
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "UpdateDestination", mock.Anything, mock.Anything)
+		})
+
+		t.Run("Returns ErrNotOwner for anonymous URLs", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://old.com/", ShortToken: "abc123"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+
+			result, err := service.UpdateDestination(url.UpdateDestinationParams{ShortToken: "abc123", Original: "https://new.com/", Actor: "alice"})
+
+			assert.ErrorIs(t, err, url.ErrNotOwner)
+			assert.Nil(t, result)
+		})
+
+		t.Run("Returns ErrURLNotFound when URL does not exist", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "missing").Return(nil, nil)
+
+			result, err := service.UpdateDestination(url.UpdateDestinationParams{ShortToken: "missing", Original: "https://new.com/", Actor: "alice"})
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
 			assert.Nil(t, result)
+		})
+
+		t.Run("Does nothing when destination is unchanged", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://old.com/", ShortToken: "abc123", Owner: "alice"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+
+			result, err := service.UpdateDestination(url.UpdateDestinationParams{ShortToken: "abc123", Original: "HTTPS://OLD.com/", Actor: "alice"})
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
+			mockRepo.AssertNotCalled(t, "UpdateDestination", mock.Anything, mock.Anything)
+		})
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		t.Run("Soft deletes URL owned by actor", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://old.com/", ShortToken: "abc123", Owner: "alice"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("SoftDelete", existing).Return(nil)
+
+			err := service.Delete("abc123", "alice")
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrNotOwner for another caller", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{Original: "https://old.com/", ShortToken: "abc123", Owner: "alice"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+
+			err := service.Delete("abc123", "bob")
+
+			assert.ErrorIs(t, err, url.ErrNotOwner)
+			mockRepo.AssertNotCalled(t, "SoftDelete", mock.Anything)
+		})
+
+		t.Run("Returns ErrURLNotFound when URL does not exist", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "missing").Return(nil, nil)
+
+			err := service.Delete("missing", "alice")
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
+		})
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		t.Run("Restores soft-deleted URL owned by actor", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			deleted := &url.URLModel{Original: "https://old.com/", ShortToken: "abc123", Owner: "alice"}
+			mockRepo.On("FindDeletedByShortToken", "abc123").Return(deleted, nil)
+			mockRepo.On("Restore", deleted).Return(nil)
+
+			result, err := service.Restore("abc123", "alice")
+
+			assert.NoError(t, err)
+			assert.Equal(t, deleted, result)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrURLNotFound when URL is not deleted", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindDeletedByShortToken", "abc123").Return(nil, nil)
+
+			result, err := service.Restore("abc123", "alice")
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
+			assert.Nil(t, result)
+		})
+	})
+
+	t.Run("ListURLs", func(t *testing.T) {
+		t.Run("Fetches one extra link to find the next page", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			created := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+			urls := []*url.URLModel{
+				{ID: 3, CreatedAt: created, ShortToken: "c"},
+				{ID: 2, CreatedAt: created, ShortToken: "b"},
+				{ID: 1, CreatedAt: created, ShortToken: "a"},
+			}
+			mockRepo.On("ListURLs", mock.MatchedBy(func(q url.URLListQuery) bool {
+				return q.Owner == "alice" && q.Limit == 3 && q.Sort == url.ListByCreatedAt && q.After == nil
+			})).Return(urls, nil)
+
+			page, err := service.ListURLs(url.ListParams{Owner: "alice", Tag: " Launch ", Limit: 2})
+
+			assert.NoError(t, err)
+			assert.Equal(t, urls[:2], page.Items)
+			assert.NotEmpty(t, page.NextCursor)
+			mockRepo.AssertExpectations(t)
+
+			// the cursor resumes after the last link of the page
+			mockRepo.On("ListURLs", mock.MatchedBy(func(q url.URLListQuery) bool {
+				return q.After != nil && q.After.ID == 2 && q.After.CreatedAt.Equal(created) && q.Tag == "launch"
+			})).Return(urls[2:], nil)
+
+			next, err := service.ListURLs(url.ListParams{Owner: "alice", Tag: "launch", Limit: 2, Cursor: page.NextCursor})
+
+			assert.NoError(t, err)
+			assert.Equal(t, urls[2:], next.Items)
+			assert.Empty(t, next.NextCursor)
+		})
+
+		t.Run("Returns an empty page without a cursor", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("ListURLs", mock.Anything).Return(nil, nil)
+
+			page, err := service.ListURLs(url.ListParams{Owner: "alice"})
+
+			assert.NoError(t, err)
+			assert.NotNil(t, page.Items)
+			assert.Empty(t, page.Items)
+			assert.Empty(t, page.NextCursor)
+		})
+
+		t.Run("Returns ErrInvalidListQuery for invalid params", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+			to := from.AddDate(0, 0, -1)
+			for name, params := range map[string]url.ListParams{
+				"no owner": {},
+				"sort":     {Owner: "alice", Sort: "original"},
+				"state":    {Owner: "alice", State: "deleted"},
+				"limit":    {Owner: "alice", Limit: 101},
+				"range":    {Owner: "alice", From: &from, To: &to},
+				"domain":   {Owner: "alice", Domain: "https://example.com"},
+				"tag":      {Owner: "alice", Tag: "no spaces"},
+				"cursor":   {Owner: "alice", Cursor: "not a cursor"},
+			} {
+				_, err := service.ListURLs(params)
+				assert.ErrorIsf(t, err, url.ErrInvalidListQuery, name)
+			}
+			mockRepo.AssertNotCalled(t, "ListURLs", mock.Anything)
+		})
+
+		t.Run("Rejects a cursor from another sort order", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("ListURLs", mock.Anything).Return([]*url.URLModel{{ID: 2}, {ID: 1}}, nil)
+
+			page, err := service.ListURLs(url.ListParams{Owner: "alice", Limit: 1})
+			assert.NoError(t, err)
+
+			_, err = service.ListURLs(url.ListParams{Owner: "alice", Limit: 1, Sort: url.ListByClickCount, Cursor: page.NextCursor})
+			assert.ErrorIs(t, err, url.ErrInvalidListQuery)
+
+			_, err = service.ListURLs(url.ListParams{Owner: "alice", Limit: 1, Ascending: true, Cursor: page.NextCursor})
+			assert.ErrorIs(t, err, url.ErrInvalidListQuery)
+		})
+	})
+
+	t.Run("UnlockService", func(t *testing.T) {
+		hash, _ := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+
+		t.Run("Counts click and returns URL for correct password", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token, PasswordHash: string(hash)}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(1), nil)
+
+			result, err := service.UnlockService(token, "s3cret", url.ClickParams{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrWrongPassword for incorrect password", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token, PasswordHash: string(hash)}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+
+			result, err := service.UnlockService(token, "wrong", url.ClickParams{})
+
+			assert.ErrorIs(t, err, url.ErrWrongPassword)
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+	})
+
+	t.Run("ResolveService", func(t *testing.T) {
+		t.Run("Returns the URL without counting a click", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: "abc123"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+
+			result, err := service.ResolveService("abc123")
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+
+		t.Run("Applies the redirect checks", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			past := time.Now().Add(-time.Hour)
+			mockRepo.On("FindByShortToken", "expired").Return(&url.URLModel{Original: "https://example.com", ExpiresAt: &past}, nil)
+			mockRepo.On("FindByShortToken", "secret").Return(&url.URLModel{Original: "https://example.com", PasswordHash: "hash"}, nil)
+
+			_, err := service.ResolveService("expired")
+			assert.ErrorIs(t, err, url.ErrLinkExpired)
+
+			_, err = service.ResolveService("secret")
+			assert.ErrorIs(t, err, url.ErrPasswordNeeded)
+		})
+	})
+
+	t.Run("PreviewService", func(t *testing.T) {
+		t.Run("Describes the destination without counting a click", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			created := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			mockRepo.On("FindByShortToken", "abc123").Return(&url.URLModel{
+				Original: "https://example.com", ShortToken: "abc123", ClickCount: 4, CreatedAt: created,
+			}, nil)
+
+			preview, err := service.PreviewService("abc123")
+
+			assert.NoError(t, err)
+			assert.Equal(t, &url.Preview{ShortToken: "abc123", Original: "https://example.com", ClickCount: 4, CreatedAt: created}, preview)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+
+		t.Run("Describes expired links", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			maxClicks := 1
+			mockRepo.On("FindByShortToken", "abc123").Return(&url.URLModel{
+				Original: "https://example.com", ShortToken: "abc123", ClickCount: 1, MaxClicks: &maxClicks,
+			}, nil)
+
+			preview, err := service.PreviewService("abc123")
+
+			assert.NoError(t, err)
+			assert.True(t, preview.Expired)
+			assert.Equal(t, "https://example.com", preview.Original)
+		})
+
+		t.Run("Hides the destination of password protected links", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "abc123").Return(&url.URLModel{
+				Original: "https://example.com/secret", ShortToken: "abc123", PasswordHash: "hash",
+			}, nil)
+
+			preview, err := service.PreviewService("abc123")
+
+			assert.NoError(t, err)
+			assert.True(t, preview.Protected)
+			assert.Empty(t, preview.Original)
+		})
+
+		t.Run("Returns ErrURLNotFound for unknown token", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "missing").Return(nil, nil)
+
+			_, err := service.PreviewService("missing")
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
+		})
+	})
+
+	t.Run("PurgeDeleted", func(t *testing.T) {
+		t.Run("Purges URLs deleted before the retention cutoff", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			retention := 30 * 24 * time.Hour
+			mockRepo.On("PurgeDeleted", mock.MatchedBy(func(before time.Time) bool {
+				return time.Since(before.Add(retention)) < time.Minute
+			})).Return(int64(4), nil)
+
+			purged, err := service.PurgeDeleted(retention)
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4), purged)
+			mockRepo.AssertExpectations(t)
+		})
+	})
+
+	t.Run("RedirectService", func(t *testing.T) {
+		t.Run("Success when URL exists and click count increments", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(1), nil)
+
+			result, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Records click event with hashed IP", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{IPHashSalt: "pepper"})
+
+			token := "abc123"
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("DailySalt", mock.Anything).Return([]byte("salt"), nil)
+			mockRepo.On("RecordClick", mock.MatchedBy(func(event *url.ClickEvent) bool {
+				return event.URLID == 7 &&
+					event.Referrer == "https://news.ycombinator.com/" &&
+					event.ReferrerSource == "hackernews" &&
+					event.UserAgent == "curl/8.0" &&
+					event.AcceptLanguage == "en-US" &&
+					event.IPHash == helpers.HashIP("203.0.113.9", "pepper") &&
+					event.Browser == "curl" && !event.IsBot &&
+					!event.OccurredAt.IsZero()
+			})).Return(int64(1), nil)
+
+			_, err := service.RedirectService(token, url.ClickParams{
+				Referrer:       "https://news.ycombinator.com/",
+				UserAgent:      "curl/8.0",
+				IP:             "203.0.113.9",
+				AcceptLanguage: "en-US",
+			})
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Fingerprints human visitors with the daily salt", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token}
+			want := helpers.VisitorFingerprint("203.0.113.9", "curl/8.0", []byte("salt"))
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("DailySalt", mock.Anything).Return([]byte("salt"), nil).Once()
+			mockRepo.On("RecordClick", mock.MatchedBy(func(event *url.ClickEvent) bool {
+				return event.Visitor == want
+			})).Return(int64(1), nil).Twice()
+
+			for i := 0; i < 2; i++ {
+				_, err := service.RedirectService(token, url.ClickParams{UserAgent: "curl/8.0", IP: "203.0.113.9"})
+				assert.NoError(t, err)
+			}
+
+			// the salt is cached for the rest of the day
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Does not fingerprint bots", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("RecordClick", mock.MatchedBy(func(event *url.ClickEvent) bool {
+				return event.IsBot && event.Visitor == 0
+			})).Return(int64(1), nil)
+
+			_, err := service.RedirectService(token, url.ClickParams{UserAgent: "Googlebot/2.1", IP: "203.0.113.9"})
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+			mockRepo.AssertNotCalled(t, "DailySalt", mock.Anything)
+		})
+
+		t.Run("Records the click when the daily salt is unavailable", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("DailySalt", mock.Anything).Return(nil, errors.New("db down"))
+			mockRepo.On("RecordClick", mock.MatchedBy(func(event *url.ClickEvent) bool {
+				return !event.IsBot && event.Visitor == 0
+			})).Return(int64(1), nil)
+
+			_, err := service.RedirectService(token, url.ClickParams{UserAgent: "curl/8.0", IP: "203.0.113.9"})
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Geolocates the click IP", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			mockGeo := new(MockGeoResolver)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, mockGeo, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockGeo.On("Lookup", "203.0.113.9").Return(geoip.Location{Country: "ID", City: "Bandung"})
+			mockRepo.On("RecordClick", mock.MatchedBy(func(event *url.ClickEvent) bool {
+				return event.Country == "ID" && event.City == "Bandung"
+			})).Return(int64(1), nil)
+
+			_, err := service.RedirectService(token, url.ClickParams{IP: "203.0.113.9"})
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+			mockGeo.AssertExpectations(t)
+		})
+
+		t.Run("Hands click to the recorder", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			mockClicks := new(MockClickRecorder)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), mockClicks, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockClicks.On("Record", mock.AnythingOfType("*url.ClickEvent")).Return(nil)
+
+			_, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.NoError(t, err)
+			mockClicks.AssertExpectations(t)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+
+		t.Run("Records click synchronously for links with a click limit", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			mockClicks := new(MockClickRecorder)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), mockClicks, nil, &config.Config{})
+
+			token := "abc123"
+			maxClicks := 10
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token, MaxClicks: &maxClicks}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("DailySalt", mock.Anything).Return([]byte("salt"), nil)
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(1), nil)
+
+			_, err := service.RedirectService(token, url.ClickParams{UserAgent: "curl/8.0"})
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+			mockClicks.AssertNotCalled(t, "Record", mock.Anything)
+		})
+
+		t.Run("Marks crawlers and prefetches as bots", func(t *testing.T) {
+			for name, click := range map[string]url.ClickParams{
+				"crawler":  {UserAgent: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"},
+				"prefetch": {UserAgent: "curl/8.0", Prefetch: true},
+			} {
+				t.Run(name, func(t *testing.T) {
+					mockRepo := new(MockURLRepo)
+					mockClicks := new(MockClickRecorder)
+					service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), mockClicks, nil, &config.Config{})
+
+					token := "abc123"
+					maxClicks := 1
+					existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token, MaxClicks: &maxClicks}
+
+					mockRepo.On("FindByShortToken", token).Return(existing, nil)
+					mockClicks.On("Record", mock.MatchedBy(func(event *url.ClickEvent) bool {
+						return event.IsBot
+					})).Return(nil)
+
+					result, err := service.RedirectService(token, click)
+
+					assert.NoError(t, err)
+					assert.Equal(t, existing, result)
+					mockClicks.AssertExpectations(t)
+					mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+				})
+			}
+		})
+
+		t.Run("Returns ErrPasswordNeeded for protected URL without counting", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token, PasswordHash: "hash"}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+
+			result, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.ErrorIs(t, err, url.ErrPasswordNeeded)
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+
+		t.Run("Returns error when URL not found", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "notfound"
+
+			mockRepo.On("FindByShortToken", token).Return(nil, nil)
+
+			result, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.Error(t, err)
+			assert.Equal(t, "short URL not found", err.Error())
+			assert.Nil(t, result)
+			mockRepo.AssertExpectations(t)
+		})
+
+		t.Run("Returns ErrLinkExpired when past expiry date", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			expiresAt := time.Now().Add(-time.Hour)
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token, ExpiresAt: &expiresAt}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+
+			result, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.ErrorIs(t, err, url.ErrLinkExpired)
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+
+		t.Run("Returns ErrLinkExpired when click limit reached", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			maxClicks := 3
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token, ClickCount: 3, MaxClicks: &maxClicks}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+
+			result, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.ErrorIs(t, err, url.ErrLinkExpired)
+			assert.Nil(t, result)
+			mockRepo.AssertNotCalled(t, "RecordClick", mock.Anything)
+		})
+
+		t.Run("Returns ErrLinkExpired when a concurrent click took the last one", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			maxClicks := 3
+			existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: token, ClickCount: 2, MaxClicks: &maxClicks}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("DailySalt", mock.Anything).Return([]byte("salt"), nil)
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(0), url.ErrLinkExpired)
+
+			result, err := service.RedirectService(token, url.ClickParams{UserAgent: "curl/8.0"})
+
+			assert.ErrorIs(t, err, url.ErrLinkExpired)
+			assert.Nil(t, result)
+		})
+
+		t.Run("Redirects while under limits", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			token := "abc123"
+			maxClicks := 3
+			expiresAt := time.Now().Add(time.Hour)
+			existing := &url.URLModel{Original: "https://example.com", ShortToken: token, ClickCount: 2, MaxClicks: &maxClicks, ExpiresAt: &expiresAt}
+
+			mockRepo.On("FindByShortToken", token).Return(existing, nil)
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(1), nil)
+
+			result, err := service.RedirectService(token, url.ClickParams{})
+
+			assert.NoError(t, err)
+			assert.Equal(t, existing, result)
 			mockRepo.AssertExpectations(t)
 		})
 
 		t.Run("Returns error when repo FindByShortToken fails", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
 			token := "error"
 
 			mockRepo.On("FindByShortToken", token).Return(nil, errors.New("db error"))
 
-			result, err := service.RedirectService(token)
+			result, err := service.RedirectService(token, url.ClickParams{})
 
 			assert.Error(t, err)
 			assert.Equal(t, "db error", err.Error())
@@ -179,15 +1297,15 @@ func TestURLService(t *testing.T) {
 
 		t.Run("Returns error when increment click count fails", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
 			token := "abc123"
 			existing := &url.URLModel{Original: "https://example.com", ShortToken: token}
 
 			mockRepo.On("FindByShortToken", token).Return(existing, nil)
-			mockRepo.On("IncrementClickCount", token).Return(int64(0), errors.New("update failed"))
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(0), errors.New("update failed"))
 
-			result, err := service.RedirectService(token)
+			result, err := service.RedirectService(token, url.ClickParams{})
 
 			assert.Error(t, err)
 			assert.Equal(t, "update failed", err.Error())
@@ -197,15 +1315,15 @@ func TestURLService(t *testing.T) {
 
 		t.Run("Returns error when no rows affected by increment", func(t *testing.T) {
 			mockRepo := new(MockURLRepo)
-			service := url.NewURLService(mockRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
 
 			token := "abc123"
 			existing := &url.URLModel{Original: "https://example.com", ShortToken: token}
 
 			mockRepo.On("FindByShortToken", token).Return(existing, nil)
-			mockRepo.On("IncrementClickCount", token).Return(int64(0), nil)
+			mockRepo.On("RecordClick", mock.AnythingOfType("*url.ClickEvent")).Return(int64(0), nil)
 
-			result, err := service.RedirectService(token)
+			result, err := service.RedirectService(token, url.ClickParams{})
 
 			assert.Error(t, err)
 			assert.Equal(t, "unable to update click statistics", err.Error())
@@ -0,0 +1,442 @@
+package unit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/helpers"
+	"github.com/nabilfikrisp/url-shortener/internal/config"
+	"github.com/nabilfikrisp/url-shortener/internal/features/url"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestURLStats(t *testing.T) {
+	existing := &url.URLModel{ID: 7, Original: "https://example.com", ShortToken: "abc123", Owner: "alice"}
+
+	t.Run("ClickTimeseries", func(t *testing.T) {
+		t.Run("Zero-fills empty buckets", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			to := time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC)
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("CountClicksByBucket", url.ClickBucketParams{
+				URLID: 7, Interval: url.StatsIntervalDay, Timezone: "UTC", From: from, To: to,
+			}).Return([]url.ClickBucketCount{
+				{Bucket: time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC), Count: 4},
+				{Bucket: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC), Count: 1},
+			}, nil)
+
+			series, err := service.ClickTimeseries(url.TimeseriesParams{
+				ShortToken: "abc123", Interval: url.StatsIntervalDay, From: &from, To: &to,
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), series.Total)
+			clicks := []int64{}
+			for _, bucket := range series.Buckets {
+				clicks = append(clicks, bucket.Clicks)
+			}
+			assert.Equal(t, []int64{0, 4, 0, 1}, clicks)
+			assert.True(t, series.Buckets[1].Start.Equal(time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)))
+		})
+
+		t.Run("Buckets days in the requested timezone", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			loc, _ := time.LoadLocation("Asia/Jakarta")
+			from := time.Date(2025, 3, 1, 0, 0, 0, 0, loc)
+			to := time.Date(2025, 3, 3, 0, 0, 0, 0, loc)
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("CountClicksByBucket", mock.MatchedBy(func(params url.ClickBucketParams) bool {
+				return params.Timezone == "Asia/Jakarta"
+			})).Return([]url.ClickBucketCount{
+				{Bucket: time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC), Count: 3},
+			}, nil)
+
+			series, err := service.ClickTimeseries(url.TimeseriesParams{
+				ShortToken: "abc123", Interval: url.StatsIntervalDay, From: &from, To: &to, Location: loc,
+			})
+
+			assert.NoError(t, err)
+			assert.Len(t, series.Buckets, 2)
+			assert.Equal(t, "2025-03-02T00:00:00+07:00", series.Buckets[1].Start.Format(time.RFC3339))
+			assert.Equal(t, int64(3), series.Buckets[1].Clicks)
+		})
+
+		t.Run("Keeps day buckets whole across DST changes", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			loc, _ := time.LoadLocation("America/New_York")
+			from := time.Date(2025, 3, 8, 0, 0, 0, 0, loc)
+			to := time.Date(2025, 3, 11, 0, 0, 0, 0, loc)
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("CountClicksByBucket", mock.Anything).Return([]url.ClickBucketCount{}, nil)
+
+			series, err := service.ClickTimeseries(url.TimeseriesParams{
+				ShortToken: "abc123", Interval: url.StatsIntervalDay, From: &from, To: &to, Location: loc,
+			})
+
+			assert.NoError(t, err)
+			starts := []string{}
+			for _, bucket := range series.Buckets {
+				starts = append(starts, bucket.Start.Format(time.RFC3339))
+			}
+			assert.Equal(t, []string{
+				"2025-03-08T00:00:00-05:00",
+				"2025-03-09T00:00:00-05:00",
+				"2025-03-10T00:00:00-04:00",
+			}, starts)
+		})
+
+		t.Run("Starts weeks on Monday", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2025, 3, 5, 12, 0, 0, 0, time.UTC) // Wednesday
+			to := time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("CountClicksByBucket", mock.Anything).Return([]url.ClickBucketCount{}, nil)
+
+			series, err := service.ClickTimeseries(url.TimeseriesParams{
+				ShortToken: "abc123", Interval: url.StatsIntervalWeek, From: &from, To: &to,
+			})
+
+			assert.NoError(t, err)
+			assert.Len(t, series.Buckets, 2)
+			assert.Equal(t, time.Monday, series.Buckets[0].Start.Weekday())
+			assert.Equal(t, 3, series.Buckets[0].Start.Day())
+		})
+
+		t.Run("Rejects ranges with too many buckets", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			to := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+			series, err := service.ClickTimeseries(url.TimeseriesParams{
+				ShortToken: "abc123", Interval: url.StatsIntervalHour, From: &from, To: &to,
+			})
+
+			assert.ErrorIs(t, err, url.ErrInvalidStatsQuery)
+			assert.Nil(t, series)
+			mockRepo.AssertNotCalled(t, "CountClicksByBucket", mock.Anything)
+		})
+
+		t.Run("Returns ErrURLNotFound for unknown token", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "missing").Return(nil, nil)
+
+			_, err := service.ClickTimeseries(url.TimeseriesParams{ShortToken: "missing", Interval: url.StatsIntervalDay})
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
+		})
+	})
+
+	t.Run("ClickBreakdowns", func(t *testing.T) {
+		t.Run("Returns top values with their share", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			scope := url.ClickRangeParams{URLID: 7}
+			mockRepo.On("CountClicks", scope).Return(int64(8), nil)
+			mockRepo.On("TopClickValues", url.ClickTopParams{ClickRangeParams: scope, Dimension: "os", Limit: 2}).Return([]url.BreakdownItem{
+				{Value: "iOS", Clicks: 6},
+				{Value: "", Clicks: 2},
+			}, nil)
+
+			breakdowns, err := service.ClickBreakdowns(url.BreakdownParams{URLID: 7, Dimensions: []string{"os"}, Top: 2})
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(8), breakdowns.Total)
+			assert.Equal(t, []url.BreakdownItem{
+				{Value: "iOS", Clicks: 6, Share: 0.75},
+				{Value: "Unknown", Clicks: 2, Share: 0.25},
+			}, breakdowns.Top["os"])
+		})
+
+		t.Run("Defaults to every dimension and the top 5", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("CountClicks", mock.Anything).Return(int64(0), nil)
+			mockRepo.On("TopClickValues", mock.MatchedBy(func(params url.ClickTopParams) bool {
+				return params.Limit == 5
+			})).Return([]url.BreakdownItem{}, nil)
+
+			breakdowns, err := service.ClickBreakdowns(url.BreakdownParams{URLID: 7})
+
+			assert.NoError(t, err)
+			assert.Len(t, breakdowns.Top, len(url.BreakdownDimensions))
+			mockRepo.AssertNumberOfCalls(t, "TopClickValues", len(url.BreakdownDimensions))
+		})
+
+		t.Run("Rejects unknown dimensions", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			_, err := service.ClickBreakdowns(url.BreakdownParams{URLID: 7, Dimensions: []string{"shoe_size"}})
+
+			assert.ErrorIs(t, err, url.ErrInvalidStatsQuery)
+			mockRepo.AssertNotCalled(t, "CountClicks", mock.Anything)
+		})
+	})
+
+	t.Run("UniqueVisitors", func(t *testing.T) {
+		sketchBytes := func(from, to int) []byte {
+			data, _ := sketchOf(from, to).MarshalBinary()
+			return data
+		}
+
+		t.Run("Merges daily sketches and zero-fills missing days", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			to := time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("FindVisitorSketches", uint(7), from, to).Return([]url.VisitorSketch{
+				{URLID: 7, Day: from, Sketch: sketchBytes(0, 60)},
+				{URLID: 7, Day: time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), Sketch: sketchBytes(40, 100)},
+			}, nil)
+
+			visitors, err := service.UniqueVisitors(url.VisitorsParams{ShortToken: "abc123", From: &from, To: &to})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "2025-03-01", visitors.From)
+			assert.Equal(t, "2025-03-04", visitors.To)
+			assertWithin(t, 100, visitors.Total, 0.03)
+			assert.Len(t, visitors.Days, 3)
+			assert.Equal(t, "2025-03-02", visitors.Days[1].Date)
+			assertWithin(t, 60, visitors.Days[0].Visitors, 0.03)
+			assert.Equal(t, uint64(0), visitors.Days[1].Visitors)
+			assertWithin(t, 60, visitors.Days[2].Visitors, 0.03)
+		})
+
+		t.Run("Rounds a to within a day up to the next day", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			to := time.Date(2025, 3, 2, 9, 30, 0, 0, time.UTC)
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("FindVisitorSketches", uint(7), from, time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)).Return([]url.VisitorSketch{}, nil)
+
+			visitors, err := service.UniqueVisitors(url.VisitorsParams{ShortToken: "abc123", From: &from, To: &to})
+
+			assert.NoError(t, err)
+			assert.Len(t, visitors.Days, 2)
+		})
+
+		t.Run("Defaults to the last 30 days", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("FindVisitorSketches", uint(7), mock.Anything, mock.Anything).Return([]url.VisitorSketch{}, nil)
+
+			visitors, err := service.UniqueVisitors(url.VisitorsParams{ShortToken: "abc123"})
+
+			assert.NoError(t, err)
+			assert.Len(t, visitors.Days, 30)
+			assert.Equal(t, time.Now().UTC().Format(time.DateOnly), visitors.Days[29].Date)
+			assert.Equal(t, uint64(0), visitors.Total)
+		})
+
+		t.Run("Rejects empty and oversized ranges", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+			later := day.AddDate(2, 0, 0)
+
+			_, err := service.UniqueVisitors(url.VisitorsParams{ShortToken: "abc123", From: &later, To: &day})
+			assert.ErrorIs(t, err, url.ErrInvalidStatsQuery)
+
+			_, err = service.UniqueVisitors(url.VisitorsParams{ShortToken: "abc123", From: &day, To: &later})
+			assert.ErrorIs(t, err, url.ErrInvalidStatsQuery)
+
+			mockRepo.AssertNotCalled(t, "FindByShortToken", mock.Anything)
+		})
+
+		t.Run("Returns ErrURLNotFound for unknown token", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "missing").Return(nil, url.ErrURLNotFound)
+
+			_, err := service.UniqueVisitors(url.VisitorsParams{ShortToken: "missing"})
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
+		})
+	})
+
+	t.Run("ExportStats", func(t *testing.T) {
+		occurred := time.Date(2025, 3, 1, 20, 30, 0, 0, time.UTC)
+		clicks := []url.ExportedClick{
+			{ShortToken: "abc123", OccurredAt: occurred, Referrer: "https://t.co/x", ReferrerSource: "x", Browser: "Chrome", Device: "mobile"},
+			{ShortToken: "abc123", OccurredAt: occurred.Add(time.Minute), UserAgent: "=HYPERLINK(\"http://evil\")", IsBot: true},
+		}
+
+		t.Run("Streams click events as CSV", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			loc, _ := time.LoadLocation("Asia/Jakarta")
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("EachClickEvent", url.ClickExportParams{URLID: 7, Timezone: "Asia/Jakarta"}).Return(clicks, nil)
+
+			export, err := service.ExportStats(url.ExportParams{
+				ShortToken: "abc123", Owner: "alice", Format: url.ExportCSV, Data: url.ExportEvents, Location: loc,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "abc123-events.csv", export.Filename)
+
+			var out strings.Builder
+			assert.NoError(t, export.Stream(&out))
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			assert.Len(t, lines, 3)
+			assert.Equal(t, "short_token,occurred_at,referrer,referrer_source,user_agent,ip_hash,accept_language,browser,os,device,country,city,is_bot", lines[0])
+			assert.Equal(t, "abc123,2025-03-02T03:30:00+07:00,https://t.co/x,x,,,,Chrome,,mobile,,,false", lines[1])
+			// formulas are defused for spreadsheets
+			assert.Contains(t, lines[2], `"'=HYPERLINK(""http://evil"")"`)
+		})
+
+		t.Run("Streams click events as NDJSON", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("EachClickEvent", mock.Anything).Return(clicks, nil)
+
+			export, err := service.ExportStats(url.ExportParams{ShortToken: "abc123", Owner: "alice", Format: url.ExportNDJSON, Data: url.ExportEvents})
+			assert.NoError(t, err)
+
+			var out strings.Builder
+			assert.NoError(t, export.Stream(&out))
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			assert.Len(t, lines, 2)
+			var first url.ExportedClick
+			assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+			assert.Equal(t, "Chrome", first.Browser)
+			assert.True(t, first.OccurredAt.Equal(occurred))
+			assert.Contains(t, lines[1], `"user_agent":"=HYPERLINK`)
+		})
+
+		t.Run("Exports click events of a URL only to its owner", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			anonymous := &url.URLModel{ID: 8, ShortToken: "anon12"}
+			mockRepo.On("FindByShortToken", "abc123").Return(existing, nil)
+			mockRepo.On("FindByShortToken", "anon12").Return(anonymous, nil)
+
+			for _, params := range []url.ExportParams{
+				{ShortToken: "abc123", Owner: "bob", Format: url.ExportCSV, Data: url.ExportEvents},
+				{ShortToken: "abc123", Format: url.ExportCSV, Data: url.ExportEvents},
+				{ShortToken: "anon12", Format: url.ExportCSV, Data: url.ExportEvents},
+			} {
+				_, err := service.ExportStats(params)
+				assert.ErrorIs(t, err, url.ErrNotOwner)
+			}
+
+			// daily counts stay public, like GET /stats/:shortToken
+			export, err := service.ExportStats(url.ExportParams{ShortToken: "abc123", Owner: "bob", Format: url.ExportCSV, Data: url.ExportDaily})
+			assert.NoError(t, err)
+			assert.Equal(t, "abc123-daily.csv", export.Filename)
+			mockRepo.AssertNotCalled(t, "EachClickEvent", mock.Anything)
+		})
+
+		t.Run("Streams daily counts of every owned URL", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("EachDailyClicks", url.ClickExportParams{Owner: "alice", Timezone: "UTC"}).Return([]url.DailyClickCount{
+				{ShortToken: "abc123", Date: "2025-03-01", Clicks: 4, BotClicks: 1},
+				{ShortToken: "def456", Date: "2025-03-01", Clicks: 2},
+			}, nil)
+
+			export, err := service.ExportStats(url.ExportParams{Owner: "alice", Format: url.ExportCSV, Data: url.ExportDaily})
+			assert.NoError(t, err)
+			assert.Equal(t, "account-daily.csv", export.Filename)
+
+			var out strings.Builder
+			assert.NoError(t, export.Stream(&out))
+			assert.Equal(t, "short_token,date,clicks,bot_clicks\nabc123,2025-03-01,4,1\ndef456,2025-03-01,2,0\n", out.String())
+			mockRepo.AssertNotCalled(t, "FindByShortToken", mock.Anything)
+		})
+
+		t.Run("Writes the CSV header without rows", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("EachDailyClicks", mock.Anything).Return(nil, nil)
+
+			export, err := service.ExportStats(url.ExportParams{Owner: "alice", Format: url.ExportCSV, Data: url.ExportDaily})
+			assert.NoError(t, err)
+
+			var out strings.Builder
+			assert.NoError(t, export.Stream(&out))
+			assert.Equal(t, "short_token,date,clicks,bot_clicks\n", out.String())
+		})
+
+		t.Run("Returns the repository error from Stream", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("EachClickEvent", mock.Anything).Return(nil, errors.New("connection reset"))
+
+			export, err := service.ExportStats(url.ExportParams{Owner: "alice", Format: url.ExportCSV, Data: url.ExportEvents})
+			assert.NoError(t, err)
+			assert.EqualError(t, export.Stream(io.Discard), "connection reset")
+		})
+
+		t.Run("Rejects invalid params", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			from := time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)
+			to := from.AddDate(0, 0, -1)
+
+			for name, params := range map[string]url.ExportParams{
+				"format":   {Owner: "alice", Format: "xlsx", Data: url.ExportEvents},
+				"data":     {Owner: "alice", Format: url.ExportCSV, Data: "hourly"},
+				"range":    {Owner: "alice", Format: url.ExportCSV, Data: url.ExportEvents, From: &from, To: &to},
+				"no owner": {Format: url.ExportCSV, Data: url.ExportEvents},
+			} {
+				_, err := service.ExportStats(params)
+				assert.ErrorIs(t, err, url.ErrInvalidStatsQuery, name)
+			}
+		})
+
+		t.Run("Returns ErrURLNotFound for unknown token", func(t *testing.T) {
+			mockRepo := new(MockURLRepo)
+			service := url.NewURLService(mockRepo, helpers.NewHashTokenGenerator(16), nil, nil, &config.Config{})
+
+			mockRepo.On("FindByShortToken", "missing").Return(nil, url.ErrURLNotFound)
+
+			_, err := service.ExportStats(url.ExportParams{ShortToken: "missing", Format: url.ExportCSV, Data: url.ExportEvents})
+
+			assert.ErrorIs(t, err, url.ErrURLNotFound)
+		})
+	})
+}
@@ -0,0 +1,30 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/features/url"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	t.Run("Value is a Postgres array literal", func(t *testing.T) {
+		value, err := url.Tags{"blog", "launch"}.Value()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "{blog,launch}", value)
+	})
+
+	t.Run("Scan reads Postgres array literals", func(t *testing.T) {
+		var tags url.Tags
+
+		assert.NoError(t, tags.Scan("{blog,launch}"))
+		assert.Equal(t, url.Tags{"blog", "launch"}, tags)
+
+		assert.NoError(t, tags.Scan([]byte("{}")))
+		assert.Empty(t, tags)
+
+		assert.NoError(t, tags.Scan(nil))
+		assert.Nil(t, tags)
+	})
+}
@@ -0,0 +1,88 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/nabilfikrisp/url-shortener/internal/common/useragent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentParse(t *testing.T) {
+	cases := []struct {
+		name string
+		ua   string
+		want useragent.Info
+	}{
+		{
+			name: "Chrome on Windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: useragent.Info{Browser: "Chrome", OS: "Windows", Device: useragent.DeviceDesktop},
+		},
+		{
+			name: "Edge on Windows",
+			ua:   "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want: useragent.Info{Browser: "Edge", OS: "Windows", Device: useragent.DeviceDesktop},
+		},
+		{
+			name: "Safari on iPhone",
+			ua:   "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want: useragent.Info{Browser: "Safari", OS: "iOS", Device: useragent.DeviceMobile},
+		},
+		{
+			name: "Chrome on iPad",
+			ua:   "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			want: useragent.Info{Browser: "Chrome", OS: "iOS", Device: useragent.DeviceTablet},
+		},
+		{
+			name: "Samsung Internet on Android phone",
+			ua:   "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			want: useragent.Info{Browser: "Samsung Internet", OS: "Android", Device: useragent.DeviceMobile},
+		},
+		{
+			name: "Android tablet",
+			ua:   "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want: useragent.Info{Browser: "Chrome", OS: "Android", Device: useragent.DeviceTablet},
+		},
+		{
+			name: "Firefox on macOS",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want: useragent.Info{Browser: "Firefox", OS: "macOS", Device: useragent.DeviceDesktop},
+		},
+		{
+			name: "Googlebot",
+			ua:   "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want: useragent.Info{Browser: "Googlebot", OS: useragent.Other, Device: useragent.DeviceBot},
+		},
+		{
+			name: "Slack link unfurler",
+			ua:   "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)",
+			want: useragent.Info{Browser: "Slackbot", OS: useragent.Other, Device: useragent.DeviceBot},
+		},
+		{
+			name: "iMessage link preview",
+			ua:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_1) AppleWebKit/601.2.4 (KHTML, like Gecko) Version/9.0.1 Safari/601.2.4 facebookexternalhit/1.1 Facebot Twitterbot/1.0",
+			want: useragent.Info{Browser: "iMessage", OS: "macOS", Device: useragent.DeviceBot},
+		},
+		{
+			name: "curl is a client, not a crawler",
+			ua:   "curl/8.5.0",
+			want: useragent.Info{Browser: "curl", OS: useragent.Other, Device: useragent.DeviceDesktop},
+		},
+		{
+			name: "unknown agent",
+			ua:   "SomethingElse/1.0",
+			want: useragent.Info{Browser: useragent.Other, OS: useragent.Other, Device: useragent.DeviceDesktop},
+		},
+		{
+			name: "empty agent",
+			ua:   "",
+			want: useragent.Info{Browser: useragent.Other, OS: useragent.Other, Device: useragent.DeviceBot},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, useragent.Parse(tc.ua))
+		})
+	}
+}